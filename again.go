@@ -1,21 +1,25 @@
 package again
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"os"
-	"os/exec"
-	"os/signal"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
+// OnForkHook is called before forking by instances without a fork hook.
+//
+// Deprecated: it is shared by every instance in the process; use
+// WithForkHook or the forkHook argument of ListenFrom instead.
 var OnForkHook func()
 
 // Don't make the caller import syscall.
@@ -23,7 +27,6 @@ const (
 	SIGINT  = syscall.SIGINT
 	SIGQUIT = syscall.SIGQUIT
 	SIGTERM = syscall.SIGTERM
-	SIGUSR2 = syscall.SIGUSR2
 )
 
 // Service is a single service listening on a single net.Listener.
@@ -32,6 +35,26 @@ type Service struct {
 	FdName     string
 	Descriptor uintptr
 	Listener   net.Listener
+	// Group is the logical service this listener belongs to, see
+	// ListenGroup.
+	Group string
+
+	// SockOpts are socket options set with SetSockOpt. They are reapplied
+	// when the listener is inherited.
+	SockOpts []SockOpt
+
+	// Hooks are run together with the instance hooks when a signal is
+	// received.
+	Hooks Hooks
+
+	conns *connSet
+	// lc is the configuration used to bind the listener, if known.
+	lc *net.ListenConfig
+	// raw is the listener owning Descriptor if Listener wraps it.
+	raw net.Listener
+	// owner is the instance the service is registered with, whose logger
+	// Serve uses.
+	owner *Again
 }
 
 // Hooks callbacks invoked when specific signal is received.
@@ -46,49 +69,213 @@ type Hooks struct {
 	OnSIGUSR1 func(*Again) error
 	// OnSIGQUIT use this for graceful shutdown
 	OnSIGQUIT func(*Again) error
+	// OnSIGTERM and OnSIGINT are called before Wait returns on SIGTERM and
+	// SIGINT, e.g. to flush buffers or deregister from service discovery.
 	OnSIGTERM func(*Again) error
+	OnSIGINT  func(*Again) error
+
+	// OnBeforeFork is called before the next generation is forked. Returning
+	// an error aborts the upgrade.
+	OnBeforeFork func(*Again) error
+	// OnChildSpawned is called after the child process has been started.
+	OnChildSpawned func(*Again, ChildInfo)
+	// OnChildReady is called when the child reports that it is serving, which
+	// happens when it kills its parent with Kill.
+	OnChildReady func(*Again, ChildInfo)
+	// OnParentExit is called just before Wait returns on a terminating signal
+	// with the error Wait is about to return.
+	OnParentExit func(*Again, error)
+	// OnUpgradeLocked is called when the upgrade lock set by WithUpgradeLock
+	// was acquired, with the time spent waiting for it. OnUpgradeUnlocked
+	// is called when it was released.
+	OnUpgradeLocked   func(*Again, time.Duration)
+	OnUpgradeUnlocked func(*Again)
+}
+
+// ChildInfo describes a child process spawned by ForkExec.
+type ChildInfo struct {
+	PID        int
+	Generation int
 }
 
 // Again manages services that need graceful restarts
 type Again struct {
 	services *sync.Map
 	Hooks    Hooks
+
+	hookConcurrency int
+	forkHook        func()
+	generation      int
+	ppid            int
+	started         time.Time
+	child           ChildInfo
+	lc              *lifecycle
+
+	parentExitTimeout time.Duration
+	idleTimeout       time.Duration
+	unixPerms         *unixPerms
+	control           func(*Service, syscall.RawConn) error
+	policy            InheritPolicy
+	policies          map[string]InheritPolicy
+	listenConfigs     map[string]*net.ListenConfig
+	inherited         []InheritResult
+	noSignals         bool
+	signalSource      <-chan os.Signal
+	signalPriority    int
+	killGroup         bool
+	exclusive         []os.Signal
+	sys               OS
+	groupHooks        map[string]GroupHook
+	nofileCheck       bool
+	nofileRaise       bool
+	forward           []os.Signal
+	childOutput       ChildOutputFunc
+	procAttr          []func(*os.ProcAttr)
+	dropUser          string
+	dropGroup         string
+	childEnv          map[string]string
+	childArgs         func([]string) []string
+	childStdio        StdioFunc
+	auditPath         string
+	drainDelay        time.Duration
+	fdServices        map[string]int
+	strategy          UpgradeStrategy
+	tickets           *ticketKeys
+	connHandoff       bool
+	socketHandoff     bool
+	controlPath       string
+	controlPipe       string
+	controlUIDs       []int
+	controlToken      []byte
+	healthCheck       HealthCheck
+	healthTimeout     time.Duration
+	warmupFn          Warmup
+	warmupTimeout     time.Duration
+	checksumPath      string
+	watch             *upgradeWatch
+	schedule          *restartSchedule
+	tracer            Tracer
+	logger            Logger
+	logLevel          slog.Level
+	quiet             bool
+	wrappers          map[string]WrapFunc
+	codecs            map[string]Codec
+	rawSetup          map[string]func(*os.File) error
+	reopeners         []Reopener
+	exePath           string
+	childDir          string
+	childDirBinary    bool
+	childUmask        *int
+	chroot            string
+	mountNS           bool
+	cgroup            *cgroupSpec
+	sandbox           func() error
+	shim              bool
+	detach            bool
+	actions           map[os.Signal]Action
+	limits            map[string]connLimit
+	envPrefix         string
+	connMaxAge        time.Duration
+	connMaxAgeJitter  time.Duration
+	reap, subreaper   bool
+	termPolicy        TermPolicy
+	registrar         Registrar
+	registrarTimeout  time.Duration
+	locker            Locker
+	lockTimeout       time.Duration
 }
 
-func New(hooks ...Hooks) Again {
-	var h Hooks
-	if len(hooks) > 0 {
-		h = hooks[0]
-	}
-	return Again{
+// New returns a new Again configured with opts. Hooks can be passed directly
+// as an option.
+func New(opts ...Option) Again {
+	a := Again{
 		services: &sync.Map{},
-		Hooks:    h,
+		lc: &lifecycle{
+			trigger:  make(chan os.Signal, triggerBuffer),
+			stop:     make(chan struct{}),
+			internal: make(chan sourcedSignal),
+		},
+		sys:     sysOS{},
+		started: time.Now(),
 	}
+	for _, o := range opts {
+		o.apply(&a)
+	}
+	return a
 }
 
+// Env returns the environment variables describing all services to the next
+// generation. It leaves the descriptors untouched; Exec and ForkExec make them
+// inheritable only for the exec itself.
 func (a *Again) Env() (m map[string]string, err error) {
+	a.lc.reg.Lock()
+	defer a.lc.reg.Unlock()
+	return a.env(a.snapshot(nil))
+}
+
+// setCloexec sets or clears FD_CLOEXEC on the descriptors of services and
+// returns the first error.
+func (a *Again) setCloexec(services []*Service, cloexec bool) error {
+	var first error
+	for _, s := range services {
+		if err := a.sys.SetCloexec(s.Descriptor, cloexec); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// snapshot returns copies of the named services, or of all services if names
+// is nil, so the description handed to the next generation can't change
+// while it is built. The caller must hold a.lc.reg.
+func (a *Again) snapshot(names []string) []*Service {
+	services := a.list()
+	if names != nil {
+		services = services[:0]
+		for _, name := range names {
+			if s := a.Get(name); s != nil {
+				services = append(services, s)
+			}
+		}
+	}
+	for i, s := range services {
+		c := *s
+		c.SockOpts = append([]SockOpt(nil), s.SockOpts...)
+		services[i] = &c
+	}
+	return services
+}
+
+// list returns all registered services sorted by name.
+func (a *Again) list() []*Service {
+	var all []*Service
+	a.Range(func(s *Service) {
+		all = append(all, s)
+	})
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// env returns the environment describing services to the next generation.
+func (a *Again) env(services []*Service) (map[string]string, error) {
 	var fds []string
 	var names []string
 	var fdNames []string
-	a.services.Range(func(k, value interface{}) bool {
-		s := value.(*Service)
+	var sockOpts []string
+	var groups []string
+	for _, s := range services {
 		names = append(names, s.Name)
-		_, _, e1 := syscall.Syscall(syscall.SYS_FCNTL, s.Descriptor, syscall.F_SETFD, 0)
-		if 0 != e1 {
-			err = e1
-			return false
-		}
 		fds = append(fds, fmt.Sprint(s.Descriptor))
 		fdNames = append(fdNames, s.FdName)
-		return true
-	})
-	if err != nil {
-		return
+		sockOpts = append(sockOpts, encodeSockOpts(s.SockOpts))
+		groups = append(groups, s.Group)
 	}
 	return map[string]string{
-		"GOAGAIN_FD":           strings.Join(fds, ","),
-		"GOAGAIN_SERVICE_NAME": strings.Join(names, ","),
-		"GOAGAIN_NAME":         strings.Join(fdNames, ","),
+		a.envName("FD"):           strings.Join(fds, ","),
+		a.envName("SERVICE_NAME"): strings.Join(names, ","),
+		a.envName("NAME"):         strings.Join(fdNames, ","),
+		a.envName("SOCKOPTS"):     strings.Join(sockOpts, ","),
+		a.envName("GROUP"):        strings.Join(groups, ","),
 	}, nil
 }
 
@@ -97,6 +284,15 @@ func ListerName(l net.Listener) string {
 	return fmt.Sprintf("%s:%s->", addr.Network(), addr.String())
 }
 
+// parseFdName splits a name created by ListerName into network and address.
+func parseFdName(name string) (network, addr string) {
+	name = strings.TrimSuffix(name, "->")
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
 func (a *Again) Range(fn func(*Service)) {
 	a.services.Range(func(k, v interface{}) bool {
 		s := v.(*Service)
@@ -105,20 +301,51 @@ func (a *Again) Range(fn func(*Service)) {
 	})
 }
 
-// Close tries to close all service listeners
+// Close tries to close all service listeners. The returned error joins a
+// *ServiceError for every listener that failed to close.
 func (a Again) Close() error {
-	var e bytes.Buffer
-	a.Range(func(s *Service) {
+	var errs []error
+	for _, s := range a.closeOrder() {
 		if err := s.Listener.Close(); err != nil {
-			e.WriteString(err.Error())
-			e.WriteByte('\n')
+			errs = append(errs, &ServiceError{Service: s.Name, Err: err})
 		}
-	})
-	if e.Len() > 0 {
-		return errors.New(e.String())
+	}
+	return errors.Join(errs...)
+}
+
+// CloseService closes the listener of the named service and removes the
+// service, so it is no longer passed to the next generation.
+func (a Again) CloseService(name string) error {
+	s := a.Get(name)
+	if s == nil {
+		return fmt.Errorf("%w: %q", ErrUnknownService, name)
+	}
+	a.Delete(name)
+	if err := s.Listener.Close(); err != nil {
+		return &ServiceError{Service: name, Err: err}
 	}
 	return nil
 }
+
+// CloseExcept closes and removes every service not named in keep.
+func (a Again) CloseExcept(keep ...string) error {
+	skip := make(map[string]bool, len(keep))
+	for _, name := range keep {
+		skip[name] = true
+	}
+	var errs []error
+	for _, s := range a.closeOrder() {
+		if skip[s.Name] {
+			continue
+		}
+		a.Delete(s.Name)
+		if err := s.Listener.Close(); err != nil {
+			errs = append(errs, &ServiceError{Service: s.Name, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func hasElem(v reflect.Value) bool {
 	switch v.Kind() {
 	case reflect.Ptr, reflect.Interface:
@@ -128,8 +355,17 @@ func hasElem(v reflect.Value) bool {
 	}
 }
 
-// Listen creates a new service with the given listener.
-func (a *Again) Listen(name string, ls net.Listener) error {
+// listenerFd returns the file descriptor of ls. Listeners implementing
+// syscall.Conn, which covers tcp, unix and unixpacket listeners including
+// abstract unix sockets, are asked directly; anything else is inspected with
+// reflection.
+func listenerFd(ls net.Listener) (uintptr, error) {
+	if fl, ok := ls.(FdListener); ok {
+		return fl.Fd()
+	}
+	if sc, ok := ls.(syscall.Conn); ok {
+		return connFd(sc)
+	}
 	v := reflect.ValueOf(ls)
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
@@ -143,11 +379,11 @@ func (a *Again) Listen(name string, ls net.Listener) error {
 		v = ls
 	}
 	if v.Kind() != reflect.Struct {
-		return fmt.Errorf("Not supported by current Go version")
+		return 0, fmt.Errorf("%w: %T", ErrUnsupportedListener, ls)
 	}
 	v = v.FieldByName("fd")
 	if !v.IsValid() {
-		return fmt.Errorf("Not supported by current Go version")
+		return 0, fmt.Errorf("%w: %T", ErrUnsupportedListener, ls)
 	}
 	v = v.Elem()
 	fdField := v.FieldByName("sysfd")
@@ -156,16 +392,92 @@ func (a *Again) Listen(name string, ls net.Listener) error {
 	}
 
 	if !fdField.IsValid() {
-		return fmt.Errorf("Not supported by current Go version")
+		return 0, fmt.Errorf("%w: %T", ErrUnsupportedListener, ls)
 	}
-	fd := uintptr(fdField.Int())
-	a.services.Store(name, &Service{
+	return uintptr(fdField.Int()), nil
+}
+
+// Listen creates a new service with the given listener. Connections are only
+// tracked when accepted through the service Listener, so serve on
+// GetListener(name) rather than ls.
+//
+// ls may wrap the socket, like a TLS or PROXY protocol listener, if the
+// wrapper has an Unwrap() net.Listener method or an exported Listener field.
+// The descriptor of the wrapped socket is passed on; register the wrapper
+// with WithListenerWrapper to have it applied again in the next generation.
+//
+// Listen may be called at any time, also while Wait is running. A service
+// registered before an upgrade starts is always passed to the next
+// generation; registrations during an upgrade wait until the child has been
+// spawned.
+//
+// Registering a name that is taken or an address another service listens
+// on fails with a DuplicateError, unless opts include Force.
+func (a *Again) Listen(name string, ls net.Listener, opts ...RegisterOption) error {
+	fd, err := listenerFd(ls)
+	if err != nil {
+		return err
+	}
+	s := &Service{
 		Name:       name,
 		FdName:     ListerName(ls),
 		Listener:   ls,
 		Descriptor: fd,
+	}
+	// A wrapped listener is kept as is, the next generation wraps the
+	// inherited one with the wrapper registered for the service.
+	if raw := unwrapListener(ls); raw != ls {
+		s.raw = raw
+	} else {
+		a.wrap(s)
+	}
+	return a.store(s, opts)
+}
+
+// store tracks the connections of s and registers it.
+func (a *Again) store(s *Service, opts []RegisterOption) error {
+	s.track()
+	a.applyLimit(s)
+	if err := a.applyUnixPerms(s); err != nil {
+		return err
+	}
+	return a.add(s, opts)
+}
+
+// WithListenConfig makes ListenFrom bind the named service with lc when it
+// binds it afresh instead of inheriting it, under InheritRebind or
+// StrategyReusePort. Pass the lc given to ListenConfig: ListenFrom runs
+// before ListenConfig and can't learn it from there.
+func WithListenConfig(name string, lc net.ListenConfig) Option {
+	return optionFunc(func(a *Again) {
+		if a.listenConfigs == nil {
+			a.listenConfigs = make(map[string]*net.ListenConfig)
+		}
+		a.listenConfigs[name] = &lc
 	})
-	return nil
+}
+
+// ListenConfig returns the listener of the named service, binding network and
+// addr with lc and registering the result when the service was not inherited
+// from the parent. Control functions of lc (SO_REUSEPORT, IP_TRANSPARENT, ...)
+// are therefore run for every fresh bind; for the one ListenFrom performs
+// when inheritance isn't possible, also pass lc to WithListenConfig.
+func (a *Again) ListenConfig(name string, lc net.ListenConfig, network, addr string) (net.Listener, error) {
+	if s := a.Get(name); s != nil {
+		s.lc = &lc
+		return s.Listener, nil
+	}
+	ls, err := lc.Listen(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.Listen(name, ls); err != nil {
+		ls.Close()
+		return nil, err
+	}
+	s := a.Get(name)
+	s.lc = &lc
+	return s.Listener, nil
 }
 
 func (a Again) Get(name string) *Service {
@@ -177,7 +489,9 @@ func (a Again) Get(name string) *Service {
 }
 
 func (a Again) Delete(name string) {
+	a.lc.reg.Lock()
 	a.services.Delete(name)
+	a.lc.reg.Unlock()
 }
 
 func (a Again) GetListener(key string) net.Listener {
@@ -190,79 +504,315 @@ func (a Again) GetListener(key string) net.Listener {
 // Re-exec this same image without dropping the net.Listener.
 func Exec(a *Again) error {
 	var pid int
-	fmt.Sscan(os.Getenv("GOAGAIN_PID"), &pid)
+	fmt.Sscan(a.getenv("PID"), &pid)
 	if syscall.Getppid() == pid {
-		return fmt.Errorf("goagain.Exec called by a child process")
+		return fmt.Errorf("%w: Exec called by a child process", ErrUpgradeInProgress)
 	}
-	argv0, err := lookPath()
+	// The new image is its own successor: there is no parent to signal.
+	return execImage(a, map[string]string{
+		a.envName(execEnv):      "1",
+		a.envName("PID"):        "",
+		a.envName("PPID"):       "",
+		a.envName("PID_START"):  "",
+		a.envName("PPID_START"): "",
+	})
+}
+
+// execImage replaces the process image handing over all services. handoff is
+// added to the environment of the new image and tells it whom to signal.
+func execImage(a *Again, handoff map[string]string) (err error) {
+	i := a.beginUpgrade(true)
+	defer func() {
+		if nil != err {
+			a.finishUpgrade(i, err)
+		}
+	}()
+	argv0, err := a.binary()
 	if nil != err {
 		return err
 	}
-	if err := setEnvs(a); nil != err {
+	wd, err := a.childWd(argv0)
+	if nil != err {
 		return err
 	}
-	if err := os.Setenv(
-		"GOAGAIN_SIGNAL",
-		fmt.Sprintf("%d", syscall.SIGQUIT),
-	); nil != err {
+	a.lc.reg.Lock()
+	defer a.lc.reg.Unlock()
+	services := a.snapshot(nil)
+	if err := a.checkNofile(len(services)); nil != err {
 		return err
 	}
-	log.Println("re-executing", argv0)
-	return syscall.Exec(argv0, os.Args, os.Environ())
-}
-
-// Fork and exec this same image without dropping the net.Listener.
-func ForkExec(a *Again) error {
-	argv0, err := lookPath()
+	env, err := a.env(services)
 	if nil != err {
 		return err
 	}
-	wd, err := os.Getwd()
-	if nil != err {
+	a.log(slog.LevelInfo, "re-executing", "path", argv0)
+	// Listeners must survive the exec, but only the exec: restore the flag
+	// if it fails so later subprocesses don't inherit them.
+	if err := a.setCloexec(services, false); nil != err {
+		a.setCloexec(services, true)
 		return err
 	}
-	err = setEnvs(a)
+	env[a.envName("GENERATION")] = fmt.Sprint(a.generation + 1)
+	env[a.envName("SIGNAL")] = fmt.Sprintf("%d", syscall.SIGQUIT)
+	env[a.envName(umaskEnv)] = a.umaskValue()
+	env[a.envName(chrootEnv)] = a.getenv(chrootEnv)
+	env[a.envName(mountNSEnv)] = a.getenv(mountNSEnv)
+	for k, v := range a.extraEnv() {
+		env[k] = v
+	}
+	for k, v := range handoff {
+		env[k] = v
+	}
+	env[a.envName(stateEnv)] = ""
+	env[a.envName(connEnv)] = ""
+	state, err := a.stateFile()
 	if nil != err {
+		a.setCloexec(services, true)
 		return err
 	}
-	if err := os.Setenv("GOAGAIN_PID", ""); nil != err {
+	if state != nil {
+		defer state.Close()
+		if err := a.sys.SetCloexec(state.Fd(), false); nil != err {
+			a.setCloexec(services, true)
+			return err
+		}
+		env[a.envName(stateEnv)] = fmt.Sprint(state.Fd())
+	}
+	a.setUnlinkOnClose(false)
+	restore := a.ignoreSignals()
+	a.finishUpgrade(i, nil)
+	// Spans can't outlive the image.
+	a.endTrace(nil)
+	cwd, cerr := os.Getwd()
+	if nil != cerr || cwd != wd {
+		err = os.Chdir(wd)
+	}
+	if nil == err {
+		err = a.sys.Exec(argv0, a.argv(), mergeEnv(os.Environ(), env))
+		if nil == cerr && cwd != wd {
+			os.Chdir(cwd)
+		}
+	}
+	restore()
+	a.setUnlinkOnClose(true)
+	a.setCloexec(services, true)
+	return err
+}
+
+// Fork and exec this same image without dropping the net.Listener.
+func ForkExec(a *Again) error {
+	return forkExec(a, nil, nil)
+}
+
+// forkExec starts the next generation handing it the named services, or all
+// services if names is nil, with extra added to its environment.
+func forkExec(a *Again, names []string, extra map[string]string) error {
+	if err := a.lockUpgrade(); err != nil {
 		return err
 	}
-	if err := os.Setenv(
-		"GOAGAIN_PPID",
-		fmt.Sprint(syscall.Getpid()),
-	); nil != err {
+	if a.Hooks.OnBeforeFork != nil {
+		if err := a.Hooks.OnBeforeFork(a); err != nil {
+			a.unlockUpgrade()
+			return err
+		}
+	}
+	i := a.beginUpgrade(false)
+	end := a.span("again.fork", Attr{"again.pid", os.Getpid()}, Attr{"again.generation", a.generation})
+	pid, err := a.spawn(names, extra)
+	end(err)
+	a.spawned(i, pid, err)
+	if nil != err {
+		a.unlockUpgrade()
 		return err
 	}
+	a.log(slog.LevelInfo, "spawned child", "pid", pid)
+	a.child = ChildInfo{PID: pid, Generation: a.generation + 1}
+	a.traceReady(a.child)
+	if a.Hooks.OnChildSpawned != nil {
+		a.Hooks.OnChildSpawned(a, a.child)
+	}
+	return nil
+}
 
-	sig := syscall.SIGQUIT
-	if err := os.Setenv("GOAGAIN_SIGNAL", fmt.Sprintf("%d", sig)); nil != err {
-		return err
+// spawn starts a new process of this image handing it the named services, or
+// all services if names is nil. The environment of the process is ours plus
+// the service description and extra. No services can be registered or
+// removed until the process has been started.
+func (a *Again) spawn(names []string, extra map[string]string) (int, error) {
+	return a.spawnImage(nil, names, extra)
+}
+
+// spawnImage is spawn starting img instead of the binary to upgrade to, if
+// it is not nil.
+func (a *Again) spawnImage(img *image, names []string, extra map[string]string) (int, error) {
+	a.lc.reg.Lock()
+	defer a.lc.reg.Unlock()
+	services := a.snapshot(names)
+	if err := a.checkNofile(len(services)); err != nil {
+		return 0, err
+	}
+	var argv0, path string
+	var err error
+	if img == nil {
+		argv0, err = a.binary()
+		path = argv0
+	} else {
+		argv0, err = img.argv0()
+		path = img.path
+	}
+	if nil != err {
+		return 0, err
+	}
+	wd, err := a.childWd(path)
+	if nil != err {
+		return 0, err
 	}
 
-	files := []*os.File{
-		os.Stdin, os.Stdout, os.Stderr,
+	files, closeStdio, err := a.stdio()
+	if nil != err {
+		return 0, err
 	}
-	a.Range(func(s *Service) {
-		files = append(files, os.NewFile(
-			s.Descriptor,
-			ListerName(s.Listener),
-		))
-	})
-	p, err := os.StartProcess(argv0, os.Args, &os.ProcAttr{
+	defer closeStdio()
+	// Hand the child close-on-exec duplicates; StartProcess installs them
+	// without the flag in the child only, so the listeners themselves never
+	// leak into unrelated subprocesses.
+	defer func() {
+		for _, f := range files[3:] {
+			f.Close()
+		}
+	}()
+	_, reuse := extra[a.envName(reusePortEnv)]
+	for _, s := range services {
+		if reuse {
+			continue
+		}
+		f, err := a.export(s)
+		if nil != err {
+			return 0, err
+		}
+		files = append(files, f)
+		// The child receives the descriptors in order right after stdio.
+		s.Descriptor = uintptr(len(files) - 1)
+	}
+	env, err := a.env(services)
+	if nil != err {
+		return 0, err
+	}
+	for k, v := range a.extraEnv() {
+		env[k] = v
+	}
+	env[a.envName(stateEnv)] = ""
+	if f, err := a.stateFile(); nil != err {
+		return 0, err
+	} else if f != nil {
+		files = append(files, f)
+		env[a.envName(stateEnv)] = fmt.Sprint(len(files) - 1)
+	}
+	_, validate := extra[a.envName(validateEnv)]
+	env[a.envName(connEnv)] = ""
+	// A validating process must not take the connection handoff of the
+	// real next generation.
+	if !validate {
+		if f, err := a.connPair(); nil != err {
+			return 0, err
+		} else if f != nil {
+			files = append(files, f)
+			env[a.envName(connEnv)] = fmt.Sprint(len(files) - 1)
+		}
+	}
+	env[a.envName(validateEnv)] = ""
+	env[a.envName("GENERATION")] = fmt.Sprint(a.generation + 1)
+	env[a.envName("PID")] = ""
+	env[a.envName("PPID")] = fmt.Sprint(syscall.Getpid())
+	env[a.envName("PID_START")] = ""
+	env[a.envName("PPID_START")] = startEnv(syscall.Getpid())
+	env[a.envName(execEnv)] = ""
+	env[a.envName(reusePortEnv)] = ""
+	env[a.envName(umaskEnv)] = a.umaskValue()
+	env[a.envName("SIGNAL")] = fmt.Sprintf("%d", syscall.SIGQUIT)
+	if a.strategy == StrategyDouble {
+		env[a.envName("SIGNAL")] = fmt.Sprintf("%d", SIGUSR2)
+	}
+	for k, v := range extra {
+		env[k] = v
+	}
+	if argv0, wd, err = a.jailPaths(argv0, wd, env); nil != err {
+		return 0, err
+	}
+	relay, err := a.outputPipes(files, env)
+	if nil != err {
+		return 0, err
+	}
+	environ := os.Environ()
+	send := func(int) {}
+	if a.socketHandoff {
+		if files, env, send, err = a.handoffSocket(files, env); nil != err {
+			relay(ChildInfo{})
+			return 0, err
+		}
+		environ = stripEnv(environ, a.envName(""))
+	}
+	attr := &os.ProcAttr{
 		Dir:   wd,
-		Env:   os.Environ(),
+		Env:   mergeEnv(environ, env),
 		Files: files,
 		Sys:   &syscall.SysProcAttr{},
-	})
+	}
+	chroot, mountNS := a.jailAttr()
+	setJail(attr.Sys, chroot, mountNS)
+	if a.detach {
+		setDetach(attr.Sys)
+	}
+	for _, fn := range a.procAttr {
+		fn(attr)
+	}
+	argv := a.argv()
+	if validate {
+		argv = append(argv, ValidateFlag)
+	}
+	pid, err := a.startProcess(argv0, argv, attr)
+	relay(ChildInfo{PID: pid, Generation: a.generation + 1})
+	send(pid)
 	if nil != err {
-		return err
+		return 0, fmt.Errorf("%w: %w", ErrChildFailed, err)
 	}
-	log.Println("spawned child", p.Pid)
-	if err = os.Setenv("GOAGAIN_PID", fmt.Sprint(p.Pid)); nil != err {
-		return err
+	if !validate {
+		for _, s := range services {
+			if u := s.unixListener(); u != nil {
+				u.SetUnlinkOnClose(false)
+			}
+		}
 	}
-	return nil
+	return pid, nil
+}
+
+// mergeEnv returns environ with the variables in set replaced or added.
+func mergeEnv(environ []string, set map[string]string) []string {
+	out := make([]string, 0, len(environ)+len(set))
+	for _, kv := range environ {
+		k := kv
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			k = kv[:i]
+		}
+		if _, ok := set[k]; !ok {
+			out = append(out, kv)
+		}
+	}
+	for k, v := range set {
+		out = append(out, k+"="+v)
+	}
+	return out
+}
+
+// stripEnv returns environ without the variables starting with prefix.
+func stripEnv(environ []string, prefix string) []string {
+	out := environ[:0:0]
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, prefix) {
+			out = append(out, kv)
+		}
+	}
+	return out
 }
 
 // IsErrClosing tests whether an error is equivalent to net.errClosing as returned by
@@ -277,9 +827,21 @@ func IsErrClosing(err error) bool {
 // Child returns true if this process is managed by again and its a child
 // process.
 func Child() bool {
-	d := os.Getenv("GOAGAIN_PID")
+	return child(nil)
+}
+
+// Child is like the package level Child but honours WithEnvPrefix.
+func (a *Again) Child() bool {
+	return child(a)
+}
+
+func child(a *Again) bool {
+	if a.getenv(execEnv) != "" || a.getenv(handoffEnv) != "" {
+		return true
+	}
+	d := a.getenv("PID")
 	if d == "" {
-		d = os.Getenv("GOAGAIN_PPID")
+		d = a.getenv("PPID")
 	}
 	var pid int
 	_, err := fmt.Sscan(d, &pid)
@@ -289,30 +851,158 @@ func Child() bool {
 // Kill process specified in the environment with the signal specified in the
 // environment; default to SIGQUIT.
 func Kill() error {
-	var (
-		pid int
-		sig syscall.Signal
-	)
-	_, err := fmt.Sscan(os.Getenv("GOAGAIN_PID"), &pid)
+	return kill(nil, sysOS{})
+}
+
+// Kill is like the package level Kill but uses the OS and environment
+// prefix of a, and tells the parent over the handoff socket if there is
+// one, see WithSocketHandoff.
+func (a *Again) Kill() error {
+	return kill(a, a.sys)
+}
+
+func kill(a *Again, sys OS) error {
+	pid, name, sig, err := killTarget(a)
+	if nil != err || pid == 0 {
+		return err
+	}
+	if err := a.notify(sys, pid, name, sig); nil != err {
+		return err
+	}
+	reapChild(a, pid)
+	return nil
+}
+
+// reapChild reaps pid once it exits if it is the child named in the PID
+// variable, as after a StrategyDouble handoff; nobody else would.
+func reapChild(a *Again, pid int) {
+	if a.getenv("PID") == fmt.Sprint(pid) {
+		go waitPid(pid)
+	}
+}
+
+// notify tells pid, found in the variable name, that we are ready, over the
+// handoff socket of a if there is one and with sig otherwise. a is nil for
+// the package level functions.
+func (a *Again) notify(sys OS, pid int, name string, sig syscall.Signal) error {
+	if a != nil && a.ackReady(pid) {
+		a.log(slog.LevelInfo, "sent ready", "pid", pid)
+		return nil
+	}
+	if err := verifyTarget(a, pid, name); err != nil {
+		return err
+	}
+	target, err := a.killPid(pid)
+	if err != nil {
+		return err
+	}
+	a.log(slog.LevelInfo, "sending signal", "signal", sig, "pid", target)
+	return sys.Kill(target, sig)
+}
+
+// KillOutcome reports how KillWithTimeout terminated the target process.
+type KillOutcome int
+
+const (
+	// KillGraceful means the process exited after the graceful signal.
+	KillGraceful KillOutcome = iota
+	// KillForced means the process had to be killed with SIGKILL.
+	KillForced
+)
+
+func (k KillOutcome) String() string {
+	if k == KillForced {
+		return "forced"
+	}
+	return "graceful"
+}
+
+// killPollInterval is how often KillWithTimeout checks whether the target
+// has exited.
+const killPollInterval = 50 * time.Millisecond
+
+// KillWithTimeout works like Kill but waits up to d for the target process to
+// exit and sends SIGKILL once the deadline has passed.
+func KillWithTimeout(d time.Duration) (KillOutcome, error) {
+	return killWithTimeout(nil, sysOS{}, d)
+}
+
+// KillWithTimeout is like the package level KillWithTimeout but uses the OS
+// and environment prefix of a, and the handoff socket like Kill.
+func (a *Again) KillWithTimeout(d time.Duration) (KillOutcome, error) {
+	return killWithTimeout(a, a.sys, d)
+}
+
+func killWithTimeout(a *Again, sys OS, d time.Duration) (KillOutcome, error) {
+	pid, name, sig, err := killTarget(a)
+	if nil != err || pid == 0 {
+		return KillGraceful, err
+	}
+	if err := a.notify(sys, pid, name, sig); nil != err {
+		return KillGraceful, err
+	}
+	reapChild(a, pid)
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		if !alive(sys, pid) {
+			return KillGraceful, nil
+		}
+		time.Sleep(killPollInterval)
+	}
+	if !alive(sys, pid) {
+		return KillGraceful, nil
+	}
+	target, err := a.killPid(pid)
+	if nil != err {
+		return KillForced, err
+	}
+	a.log(slog.LevelWarn, "sending signal", "signal", syscall.SIGKILL, "pid", target)
+	if err := sys.Kill(target, syscall.SIGKILL); nil != err && err != syscall.ESRCH {
+		return KillForced, err
+	}
+	return KillForced, nil
+}
+
+// killTarget returns the process and signal Kill should use and the
+// variable the process was found in.
+func killTarget(a *Again) (pid int, name string, sig syscall.Signal, err error) {
+	if a.getenv(execEnv) != "" {
+		// Started by Exec, the previous image is gone already.
+		return 0, "", 0, nil
+	}
+	name = "PID"
+	_, err = fmt.Sscan(a.getenv(name), &pid)
 	if io.EOF == err {
-		_, err = fmt.Sscan(os.Getenv("GOAGAIN_PPID"), &pid)
+		name = "PPID"
+		_, err = fmt.Sscan(a.getenv(name), &pid)
+	}
+	if io.EOF == err {
+		err = ErrNotChild
 	}
 	if nil != err {
-		return err
+		return
 	}
-	if _, err := fmt.Sscan(os.Getenv("GOAGAIN_SIGNAL"), &sig); nil != err {
+	if _, err := fmt.Sscan(a.getenv("SIGNAL"), &sig); nil != err {
 		sig = syscall.SIGQUIT
 	}
-	log.Println("sending signal", sig, "to process", pid)
-	return syscall.Kill(pid, sig)
+	return
 }
 
 // Listen checks env and constructs a Again instance if this is a child process
-// that was froked by again parent.
+// that was froked by again parent. A process started by inetd or a
+// ucspi-style supervisor with a listening socket on fd 0 gets an instance
+// serving it as StdinService. Otherwise it returns ErrNotChild.
 //
 // forkHook if provided will be called before forking.
 func Listen(forkHook func()) (*Again, error) {
-	a := New()
+	var opts []Option
+	if !Child() {
+		if !listening(0) {
+			return nil, ErrNotChild
+		}
+		opts = append(opts, WithInheritedFd(StdinService, 0))
+	}
+	a := New(opts...)
 	if err := ListenFrom(&a, forkHook); err != nil {
 		return nil, err
 	}
@@ -320,14 +1010,49 @@ func Listen(forkHook func()) (*Again, error) {
 }
 
 func ListenFrom(a *Again, forkHook func()) error {
-	OnForkHook = forkHook
-	fds := strings.Split(os.Getenv("GOAGAIN_FD"), ",")
-	names := strings.Split(os.Getenv("GOAGAIN_SERVICE_NAME"), ",")
-	fdNames := strings.Split(os.Getenv("GOAGAIN_NAME"), ",")
+	if forkHook != nil {
+		a.forkHook = forkHook
+	}
+	if err := a.readHandoff(); err != nil {
+		return err
+	}
+	if err := a.applyUmask(); err != nil {
+		return err
+	}
+	a.ignoreSIGPIPE()
+	fmt.Sscan(a.getenv("GENERATION"), &a.generation)
+	fmt.Sscan(a.getenv("PPID"), &a.ppid)
+	if err := a.readState(); err != nil {
+		return err
+	}
+	if err := a.restoreTickets(); err != nil {
+		return err
+	}
+	if err := a.readConnHandoff(); err != nil {
+		return err
+	}
+	fds := strings.Split(a.getenv("FD"), ",")
+	names := strings.Split(a.getenv("SERVICE_NAME"), ",")
+	fdNames := strings.Split(a.getenv("NAME"), ",")
+	sockOpts := strings.Split(a.getenv("SOCKOPTS"), ",")
+	groups := strings.Split(a.getenv("GROUP"), ",")
+	reuse := a.getenv(reusePortEnv) != ""
+	if a.getenv("FD") == "" && a.fdServices != nil {
+		if err := a.adoptFds(); err != nil {
+			return err
+		}
+	}
 	if !((len(fds) == len(names)) && (len(fds) == len(fdNames))) {
-		errors.New(("again: names/fds mismatch"))
+		return fmt.Errorf("%w: names/fds count differs", ErrFdMismatch)
 	}
-	for k, f := range fds {
+	// Handle services by name, whatever order the parent listed them in.
+	order := make([]int, len(fds))
+	for k := range order {
+		order[k] = k
+	}
+	sort.Slice(order, func(i, j int) bool { return names[order[i]] < names[order[j]] })
+	for _, k := range order {
+		f := fds[k]
 		if f == "" {
 			continue
 		}
@@ -338,120 +1063,214 @@ func ListenFrom(a *Again, forkHook func()) error {
 		}
 		s.Name = names[k]
 		s.FdName = fdNames[k]
-		l, err := net.FileListener(os.NewFile(s.Descriptor, s.FdName))
+		s.lc = a.listenConfigs[s.Name]
+		// Parents older than socket option support don't set the variable.
+		if len(sockOpts) == len(fds) {
+			if s.SockOpts, err = decodeSockOpts(sockOpts[k]); err != nil {
+				return err
+			}
+		}
+		if len(groups) == len(fds) {
+			s.Group = groups[k]
+		}
+		res := InheritResult{Service: s.Name, Outcome: Inherited}
+		if reuse {
+			err = a.bindReusePort(&s)
+		} else {
+			err = a.inherit(&s)
+		}
 		if err != nil {
-			return err
+			res.Err = err
+			switch a.inheritPolicy(s.Name) {
+			case InheritSkip:
+				a.log(slog.LevelWarn, "again: skipping service", "service", s.Name, "err", err)
+				res.Outcome = Skipped
+			case InheritRebind:
+				a.log(slog.LevelWarn, "again: rebinding service", "service", s.Name, "err", err)
+				res.Outcome = Rebound
+				if err = a.rebind(&s); err != nil {
+					res.Outcome = Failed
+					res.Err = errors.Join(res.Err, err)
+				}
+			default:
+				res.Outcome = Failed
+			}
 		}
-		s.Listener = l
-		switch l.(type) {
-		case *net.TCPListener, *net.UnixListener:
-		default:
-			return fmt.Errorf(
-				"file descriptor is %T not *net.TCPListener or *net.UnixListener",
-				l,
-			)
+		a.inherited = append(a.inherited, res)
+		if res.Outcome == Failed {
+			return res.Err
 		}
-		if err = syscall.Close(int(s.Descriptor)); nil != err {
-			return err
+		if res.Outcome == Skipped {
+			continue
+		}
+		a.log(slog.LevelDebug, "inherited service", "service", s.Name, "listener", s.FdName)
+		a.wrap(&s)
+		s.track()
+		a.applyLimit(&s)
+		// The socket file stays with the parent until claimSockets.
+		if u := s.unixListener(); u != nil {
+			u.SetUnlinkOnClose(false)
 		}
-		fmt.Println("=> ", s.Name, s.FdName)
+		s.owner = a
+		a.lc.reg.Lock()
 		a.services.Store(s.Name, &s)
+		a.lc.reg.Unlock()
+	}
+	a.claimSockets()
+	if a.dropUser != "" {
+		if err := DropPrivileges(a.dropUser, a.dropGroup); err != nil {
+			return err
+		}
+	}
+	if err := a.EnterSandbox(); err != nil {
+		a.abortHandoff(err)
+		return err
+	}
+	a.validated()
+	return a.warmup()
+}
+
+// inherit rebuilds the listener of s from its inherited descriptor with the
+// codec for its network. Codecs work on a duplicate, so the inherited
+// descriptor is closed through its *os.File afterwards and s.Descriptor
+// updated to the descriptor actually backing the listener, which is what the
+// next generation needs.
+func (a *Again) inherit(s *Service) error {
+	if err := validateFd(s); err != nil {
+		return err
+	}
+	f := os.NewFile(s.Descriptor, s.FdName)
+	network, _ := parseFdName(s.FdName)
+	c := a.codec(network)
+	if c == nil {
+		f.Close()
+		return fmt.Errorf("%w: no codec for network %q", ErrUnsupportedListener, network)
+	}
+	l, err := c.Import(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	// unix and unixpacket sockets both come back as *net.UnixListener,
+	// make sure we got the socket type the parent registered.
+	if network != "" && network != l.Addr().Network() {
+		l.Close()
+		return &FdError{
+			Service: s.Name,
+			Fd:      s.Descriptor,
+			Reason:  fmt.Sprintf("expected %s listener, got %s", network, l.Addr().Network()),
+		}
+	}
+	fd, err := listenerFd(l)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	s.Listener = l
+	s.Descriptor = fd
+	if err := a.restoreSockOpts(s); err != nil {
+		return err
+	}
+	if fn := a.rawSetup[s.Name]; fn != nil && s.File() != nil {
+		if err := fn(s.File()); err != nil {
+			return &ServiceError{Service: s.Name, Err: err}
+		}
 	}
 	return nil
 }
 
-// Wait waits for signals
+// Wait waits for signals. Errors returned by the hooks run for SIGQUIT and
+// SIGTERM are joined and returned together with the signal. After Stop it
+// returns 0 and ErrStopped. WaitResult tells more about why it returned.
 func Wait(a *Again) (syscall.Signal, error) {
-	ch := make(chan os.Signal, 2)
-	signal.Notify(
-		ch,
-		syscall.SIGHUP,
-		syscall.SIGINT,
-		syscall.SIGQUIT,
-		syscall.SIGTERM,
-		syscall.SIGUSR1,
-		syscall.SIGUSR2,
-	)
-	forked := false
-	for {
-		sig := <-ch
-		log.Println(sig.String())
-		switch sig {
-
-		// SIGHUP should reload configuration.
-		case syscall.SIGHUP:
-			if a.Hooks.OnSIGHUP != nil {
-				if err := a.Hooks.OnSIGHUP(a); err != nil {
-					log.Println("OnSIGHUP:", err)
-				}
-			}
-
-		// SIGINT should exit.
-		case syscall.SIGINT:
-			return syscall.SIGINT, nil
-
-		// SIGQUIT should exit gracefully.
-		case syscall.SIGQUIT:
-			if a.Hooks.OnSIGQUIT != nil {
-				if err := a.Hooks.OnSIGQUIT(a); err != nil {
-					log.Println("OnSIGQUIT:", err)
-				}
-			}
-			return syscall.SIGQUIT, nil
-
-		// SIGTERM should exit.
-		case syscall.SIGTERM:
-			if a.Hooks.OnSIGTERM != nil {
-				if err := a.Hooks.OnSIGHUP(a); err != nil {
-					log.Println("OnSIGTERM:", err)
-				}
-			}
-			return syscall.SIGTERM, nil
-
-		// SIGUSR1 should reopen logs.
-		case syscall.SIGUSR1:
-			if a.Hooks.OnSIGUSR1 != nil {
-				if err := a.Hooks.OnSIGUSR1(a); err != nil {
-					log.Println("OnSIGUSR1:", err)
-				}
-			}
+	r := WaitResult(a)
+	return r.Signal, r.Err
+}
 
-		// SIGUSR2 forks and re-execs the first time it is received and execs
-		// without forking from then on.
-		case syscall.SIGUSR2:
-			if OnForkHook != nil {
-				OnForkHook()
-			}
-			if forked {
-				return syscall.SIGUSR2, nil
-			}
-			forked = true
-			if err := ForkExec(a); nil != err {
-				return syscall.SIGUSR2, err
+// WaitResult is Wait returning a Result.
+func WaitResult(a *Again) Result {
+	ch := a.signalSource
+	if ch == nil && !a.noSignals {
+		c := make(chan os.Signal, 2)
+		defer muxSignals.subscribe(c, a.signalPriority, a.exclusive, a.handledSignals()...)()
+		ch = c
+	}
+	defer a.startReaping()()
+	defer a.startTicketRotation()()
+	defer a.startExpiry()()
+	defer a.startControl()()
+	defer a.startPipe()()
+	defer a.startWatch()()
+	defer a.startSchedule()()
+	if err := a.startShim(); err != nil {
+		return a.exit(0, "shim", err)
+	}
+	a.setState(Serving)
+	w := waitState{}
+	for {
+		var sig os.Signal
+		w.source = "signal"
+		// Stop wins over pending signals.
+		select {
+		case <-a.lc.stop:
+			return a.exit(0, "stop", ErrStopped)
+		default:
+		}
+		select {
+		case sig = <-ch:
+		case sig = <-a.lc.trigger:
+			w.source = "trigger"
+		case s := <-a.lc.internal:
+			sig, w.source = s.sig, s.source
+		case <-a.lc.stop:
+			return a.exit(0, "stop", ErrStopped)
+		}
+		a.log(slog.LevelInfo, "received signal", "signal", sig, "source", w.source)
+		if w.forked {
+			a.forwardSignal(sig)
+		} else if a.shimForward(sig) {
+			continue
+		}
+		if fn := steps[a.action(sig)]; fn != nil {
+			if r, done := fn(a, &w, sig); done {
+				return r
 			}
-
 		}
 	}
 }
 
-func lookPath() (argv0 string, err error) {
-	argv0, err = exec.LookPath(os.Args[0])
-	if nil != err {
-		return
+// exit runs the OnParentExit hook, moves to Stopped and returns the result
+// of Wait.
+func (a *Again) exit(sig syscall.Signal, source string, err error) Result {
+	end := a.span("again.exit", Attr{"again.pid", os.Getpid()}, Attr{"again.generation", a.generation})
+	defer func() {
+		end(err)
+		a.endTrace(err)
+	}()
+	a.unlockUpgrade()
+	if a.stopDrain() {
+		err = errors.Join(err, ErrParentExitTimeout)
 	}
-	if _, err = os.Stat(argv0); nil != err {
-		return
+	if p := a.pool(); p != nil {
+		// Stop and a failed shim start come without a signal, the
+		// workers get SIGTERM then.
+		psig := sig
+		if psig == 0 {
+			psig = syscall.SIGTERM
+		}
+		if perr := p.Stop(psig); perr != nil {
+			err = errors.Join(err, perr)
+		}
 	}
-	return
-}
-
-func setEnvs(a *Again) error {
-	e, err := a.Env()
-	if err != nil {
-		return err
+	if a.Hooks.OnParentExit != nil {
+		a.Hooks.OnParentExit(a, err)
 	}
-	for k, v := range e {
-		os.Setenv(k, v)
+	a.setState(Stopped)
+	return Result{
+		Signal: sig,
+		Source: source,
+		Child:  a.child,
+		Conns:  a.stats(),
+		Err:    err,
 	}
-	return nil
 }
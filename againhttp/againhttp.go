@@ -0,0 +1,64 @@
+// Package againhttp integrates net/http servers with again so that clients
+// move to the next generation quickly once the current one starts draining.
+package againhttp
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/TykTechnologies/again"
+)
+
+// Drainer tells HTTP clients to go away once the Again instance it was
+// created for starts draining.
+type Drainer struct {
+	srv      *http.Server
+	draining atomic.Bool
+}
+
+// New returns a Drainer for srv. It wraps srv.Handler so HTTP/1 responses
+// carry "Connection: close" while draining, and calls srv.Shutdown when
+// draining starts, which sends GOAWAY on HTTP/2 connections and closes idle
+// ones.
+//
+// New must be called before srv starts serving.
+func New(a *again.Again, srv *http.Server) *Drainer {
+	d := &Drainer{srv: srv}
+	h := srv.Handler
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	srv.Handler = d.Handler(h)
+	a.Subscribe(func(e again.Event) {
+		if e.Type == again.EventStateChanged && e.State == again.Draining {
+			d.Drain()
+		}
+	})
+	return d
+}
+
+// Handler wraps h so responses ask HTTP/1 clients to close the connection
+// while draining.
+func (d *Drainer) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.draining.Load() && r.ProtoMajor == 1 {
+			w.Header().Set("Connection", "close")
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Draining reports whether Drain has been called.
+func (d *Drainer) Draining() bool {
+	return d.draining.Load()
+}
+
+// Drain starts draining. It is called automatically on the transition to
+// again.Draining and only has an effect the first time.
+func (d *Drainer) Drain() {
+	if !d.draining.CompareAndSwap(false, true) {
+		return
+	}
+	go d.srv.Shutdown(context.Background())
+}
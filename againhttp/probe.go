@@ -0,0 +1,30 @@
+package againhttp
+
+import (
+	"net/http"
+
+	"github.com/TykTechnologies/again"
+)
+
+// ReadyHandler answers readiness probes: 200 while a is ready for traffic and
+// 503 as soon as it starts draining.
+func ReadyHandler(a *again.Again) http.Handler {
+	return probe(a.Ready)
+}
+
+// LiveHandler answers liveness probes: 200 until a has stopped, in every
+// generation.
+func LiveHandler(a *again.Again) http.Handler {
+	return probe(a.Live)
+}
+
+func probe(ok func() bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if !ok() {
+			http.Error(w, "not ok", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,79 @@
+package againhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/TykTechnologies/again"
+)
+
+// Status is the document served by StatusHandler.
+type Status struct {
+	State      string          `json:"state"`
+	PID        int             `json:"pid"`
+	ParentPID  int             `json:"parent_pid,omitempty"`
+	Generation int             `json:"generation"`
+	Started    time.Time       `json:"started"`
+	Services   []ServiceStatus `json:"services"`
+	// LastUpgrade is the most recent upgrade attempt of this process.
+	LastUpgrade *again.UpgradeRecord `json:"last_upgrade,omitempty"`
+}
+
+// ServiceStatus describes a single service in a Status.
+type ServiceStatus struct {
+	Name    string `json:"name"`
+	Network string `json:"network"`
+	Address string `json:"address"`
+	Group   string `json:"group,omitempty"`
+	Active  int    `json:"active_conns"`
+	Total   int64  `json:"total_conns"`
+	Paused  bool   `json:"paused,omitempty"`
+}
+
+// GetStatus returns the current status of a.
+func GetStatus(a *again.Again) Status {
+	st := Status{
+		State:      a.State().String(),
+		PID:        os.Getpid(),
+		ParentPID:  a.ParentPID(),
+		Generation: a.Generation(),
+		Started:    a.StartTime(),
+		Services:   []ServiceStatus{},
+	}
+	a.Range(func(s *again.Service) {
+		stats := s.Stats()
+		ss := ServiceStatus{
+			Name:   s.Name,
+			Group:  s.Group,
+			Active: stats.Active,
+			Total:  stats.Total,
+			Paused: s.Paused(),
+		}
+		if addr := s.Listener.Addr(); addr != nil {
+			ss.Network, ss.Address = addr.Network(), addr.String()
+		}
+		st.Services = append(st.Services, ss)
+	})
+	sort.Slice(st.Services, func(i, j int) bool {
+		return st.Services[i].Name < st.Services[j].Name
+	})
+	if h := a.History(); len(h) > 0 {
+		st.LastUpgrade = &h[len(h)-1]
+	}
+	return st
+}
+
+// StatusHandler returns a handler serving the status of a as JSON, to be
+// mounted on an existing admin mux.
+func StatusHandler(a *again.Again) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(GetStatus(a))
+	})
+}
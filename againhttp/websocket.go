@@ -0,0 +1,159 @@
+package againhttp
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/again"
+)
+
+// CloseServiceRestart is the WebSocket close code telling clients that the
+// server is restarting and they should reconnect.
+const CloseServiceRestart = 1012
+
+// closeReason is sent along with CloseServiceRestart.
+const closeReason = "restarting"
+
+// wsPollInterval is how often Wait checks for remaining WebSockets.
+const wsPollInterval = 100 * time.Millisecond
+
+// CloseFunc sends a WebSocket close frame with code and reason. Use the
+// WebSocket library so the frame isn't interleaved with others, e.g. with
+// gorilla/websocket:
+//
+//	func(code int, reason string) error {
+//		msg := websocket.FormatCloseMessage(code, reason)
+//		return ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+//	}
+type CloseFunc func(code int, reason string) error
+
+// WebSockets asks the clients of hijacked WebSocket connections to
+// reconnect once the Again instance it was created for starts draining,
+// instead of cutting them all at once and causing a retry storm.
+type WebSockets struct {
+	mu       sync.Mutex
+	conns    map[*wsConn]struct{}
+	draining bool
+}
+
+type wsConn struct {
+	net.Conn
+	close CloseFunc
+	once  sync.Once
+}
+
+// NewWebSockets returns a WebSockets that calls Drain on the transition to
+// again.Draining.
+func NewWebSockets(a *again.Again) *WebSockets {
+	w := &WebSockets{conns: make(map[*wsConn]struct{})}
+	a.Subscribe(func(e again.Event) {
+		if e.Type == again.EventStateChanged && e.State == again.Draining {
+			w.Drain()
+		}
+	})
+	return w
+}
+
+// Track registers c, the connection hijacked for a WebSocket, until the
+// returned function is called when the WebSocket ends. close sends the close
+// frame; if it is nil the frame is written to c directly, which is only safe
+// if nothing else writes to c concurrently. A connection tracked while
+// draining is asked to reconnect right away.
+func (w *WebSockets) Track(c net.Conn, close CloseFunc) (done func()) {
+	wc := &wsConn{Conn: c, close: close}
+	w.mu.Lock()
+	w.conns[wc] = struct{}{}
+	draining := w.draining
+	w.mu.Unlock()
+	if draining {
+		go wc.restart()
+	}
+	return func() {
+		w.mu.Lock()
+		delete(w.conns, wc)
+		w.mu.Unlock()
+	}
+}
+
+// Drain sends a close frame with CloseServiceRestart to every tracked
+// WebSocket. It only has an effect the first time.
+func (w *WebSockets) Drain() {
+	w.mu.Lock()
+	if w.draining {
+		w.mu.Unlock()
+		return
+	}
+	w.draining = true
+	conns := w.list()
+	w.mu.Unlock()
+	for _, wc := range conns {
+		go wc.restart()
+	}
+}
+
+// Wait blocks until every tracked WebSocket has ended. If ctx is done first
+// the remaining connections are closed and ctx.Err() is returned.
+func (w *WebSockets) Wait(ctx context.Context) error {
+	t := time.NewTicker(wsPollInterval)
+	defer t.Stop()
+	for w.Len() > 0 {
+		select {
+		case <-ctx.Done():
+			w.mu.Lock()
+			conns := w.list()
+			w.mu.Unlock()
+			for _, wc := range conns {
+				wc.Close()
+			}
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
+// Len returns the number of tracked WebSockets.
+func (w *WebSockets) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.conns)
+}
+
+// list returns a snapshot of the tracked connections; w.mu must be held.
+func (w *WebSockets) list() []*wsConn {
+	l := make([]*wsConn, 0, len(w.conns))
+	for wc := range w.conns {
+		l = append(l, wc)
+	}
+	return l
+}
+
+// restart asks the client to reconnect, closing the connection if the
+// close frame can't be sent.
+func (wc *wsConn) restart() {
+	wc.once.Do(func() {
+		var err error
+		if wc.close != nil {
+			err = wc.close(CloseServiceRestart, closeReason)
+		} else {
+			_, err = wc.Write(CloseFrame(CloseServiceRestart, closeReason))
+		}
+		if err != nil {
+			wc.Close()
+		}
+	})
+}
+
+// CloseFrame returns an unmasked WebSocket close frame, as sent by servers,
+// with code and reason. reason is truncated to fit a control frame.
+func CloseFrame(code int, reason string) []byte {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	b := []byte{0x88, byte(2 + len(reason))}
+	b = binary.BigEndian.AppendUint16(b, uint16(code))
+	return append(b, reason...)
+}
@@ -0,0 +1,201 @@
+// Package againtest simulates the parent/child handoff of again inside a
+// single test binary.
+//
+// The parent side of a test calls StartChild, which re-executes the test
+// binary running only the named test with the services of an Again instance
+// passed the same way ForkExec passes them. The child side detects this with
+// IsChild and rebuilds the services with Inherit:
+//
+//	func TestUpgrade(t *testing.T) {
+//		if againtest.IsChild() {
+//			a := againtest.Inherit(t)
+//			// serve on a.GetListener("web") ...
+//			return
+//		}
+//		a := again.New()
+//		// register listeners ...
+//		c := againtest.StartChild(t, &a, "TestUpgrade")
+//		defer c.Stop()
+//		againtest.AssertAccepts(t, "tcp", addr)
+//	}
+package againtest
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/TykTechnologies/again"
+)
+
+// ChildEnv is set in the environment of test binaries started by StartChild.
+const ChildEnv = "AGAINTEST_CHILD"
+
+// IsChild reports whether the running test binary was started by StartChild.
+func IsChild() bool {
+	return os.Getenv(ChildEnv) == "1"
+}
+
+// Child is a test binary started by StartChild.
+type Child struct {
+	Cmd *exec.Cmd
+	t   testing.TB
+}
+
+// StartChild re-executes the test binary running only test and hands it the
+// services of a. Signals the child sends to its parent with again.Kill are
+// turned into no-ops so they don't hit the test process. The handoff uses
+// the variable names of a, so the child has to pass Inherit the same
+// again.WithEnvPrefix, if any.
+func StartChild(t testing.TB, a *again.Again, test string) *Child {
+	t.Helper()
+	env, err := a.Env()
+	if err != nil {
+		t.Fatalf("againtest: Env: %v", err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^"+test+"$", "-test.v")
+	// Descriptors are renumbered from 3 in the child, rewrite the list.
+	var fds []string
+	for _, f := range strings.Split(env[a.EnvName("FD")], ",") {
+		if f == "" {
+			continue
+		}
+		var fd int
+		if _, err := fmt.Sscan(f, &fd); err != nil {
+			t.Fatalf("againtest: bad descriptor %q: %v", f, err)
+		}
+		// Pass a copy so closing our *os.File leaves the listener alone.
+		nfd, err := dup(fd)
+		if err != nil {
+			t.Fatalf("againtest: dup %d: %v", fd, err)
+		}
+		fds = append(fds, fmt.Sprint(3+len(cmd.ExtraFiles)))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, os.NewFile(uintptr(nfd), f))
+	}
+	env[a.EnvName("FD")] = strings.Join(fds, ",")
+	env[a.EnvName("PPID")] = fmt.Sprint(os.Getpid())
+	env[a.EnvName("PID")] = ""
+	env[a.EnvName("SIGNAL")] = "0"
+	env[ChildEnv] = "1"
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	for _, f := range cmd.ExtraFiles {
+		f.Close()
+	}
+	if err != nil {
+		t.Fatalf("againtest: starting child: %v", err)
+	}
+	return &Child{Cmd: cmd, t: t}
+}
+
+// Signal sends sig to the child.
+func (c *Child) Signal(sig os.Signal) {
+	c.t.Helper()
+	if err := c.Cmd.Process.Signal(sig); err != nil {
+		c.t.Fatalf("againtest: signal %v: %v", sig, err)
+	}
+}
+
+// Wait waits for the child and fails the test if it didn't exit cleanly.
+func (c *Child) Wait() {
+	c.t.Helper()
+	if err := c.Cmd.Wait(); err != nil {
+		c.t.Fatalf("againtest: child: %v", err)
+	}
+}
+
+// Stop kills the child if it is still running.
+func (c *Child) Stop() {
+	c.Cmd.Process.Signal(syscall.SIGKILL)
+	c.Cmd.Wait()
+}
+
+// Inherit rebuilds the services passed by the parent test and fails the test
+// on error.
+func Inherit(t testing.TB, opts ...again.Option) *again.Again {
+	t.Helper()
+	a := again.New(opts...)
+	if err := again.ListenFrom(&a, nil); err != nil {
+		t.Fatalf("againtest: ListenFrom: %v", err)
+	}
+	return &a
+}
+
+// RoundTrip passes the services of a through Env and ListenFrom within the
+// current process and returns the rebuilt instance. It checks that every
+// service survives with the same name and address. The rebuilt instance
+// uses the environment prefix of a unless opts say otherwise.
+func RoundTrip(t testing.TB, a *again.Again, opts ...again.Option) *again.Again {
+	t.Helper()
+	env, err := a.Env()
+	if err != nil {
+		t.Fatalf("againtest: Env: %v", err)
+	}
+	// ListenFrom closes the descriptors it is given, hand it copies.
+	var fds []string
+	for _, f := range strings.Split(env[a.EnvName("FD")], ",") {
+		if f == "" {
+			continue
+		}
+		var fd int
+		fmt.Sscan(f, &fd)
+		nfd, err := dup(fd)
+		if err != nil {
+			t.Fatalf("againtest: dup %d: %v", fd, err)
+		}
+		fds = append(fds, fmt.Sprint(nfd))
+	}
+	env[a.EnvName("FD")] = strings.Join(fds, ",")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	opts = append([]again.Option{again.WithEnvPrefix(a.EnvPrefix())}, opts...)
+	b := again.New(opts...)
+	if err := again.ListenFrom(&b, nil); err != nil {
+		t.Fatalf("againtest: ListenFrom: %v", err)
+	}
+	a.Range(func(s *again.Service) {
+		got := b.Get(s.Name)
+		if got == nil {
+			t.Errorf("againtest: service %s lost in handoff", s.Name)
+			return
+		}
+		if got.FdName != s.FdName {
+			t.Errorf("againtest: service %s: got %s, want %s", s.Name, got.FdName, s.FdName)
+		}
+	})
+	return &b
+}
+
+// AssertAccepts fails the test if addr doesn't accept connections.
+func AssertAccepts(t testing.TB, network, addr string) {
+	t.Helper()
+	c, err := net.DialTimeout(network, addr, time.Second)
+	if err != nil {
+		t.Fatalf("againtest: %s %s not accepting: %v", network, addr, err)
+	}
+	c.Close()
+}
+
+// AssertDrained fails the test if s still has active connections after
+// timeout.
+func AssertDrained(t testing.TB, s *again.Service, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for s.Stats().Active > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("againtest: service %s has %d active connections", s.Name, s.Stats().Active)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,62 @@
+package againtest
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/TykTechnologies/again"
+)
+
+const testPrefix = "AGAINTEST_APP"
+
+func listen(t *testing.T, a *again.Again, name string) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Listen(name, l); err != nil {
+		l.Close()
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestRoundTripEnvPrefix(t *testing.T) {
+	t.Setenv("GOAGAIN_FD", "")
+	a := again.New(again.WithEnvPrefix(testPrefix))
+	l := listen(t, &a, "web")
+	defer a.Close()
+	b := RoundTrip(t, &a)
+	defer b.Close()
+	if got := b.EnvPrefix(); got != testPrefix {
+		t.Errorf("prefix %q, want %q", got, testPrefix)
+	}
+	if v := os.Getenv("GOAGAIN_FD"); v != "" {
+		t.Errorf("GOAGAIN_FD = %q, want it unset", v)
+	}
+	if b.Get("web") == nil {
+		t.Fatal("web not inherited")
+	}
+	AssertAccepts(t, "tcp", l.Addr().String())
+}
+
+func TestStartChildEnvPrefix(t *testing.T) {
+	if IsChild() {
+		if os.Getenv("GOAGAIN_FD") != "" {
+			t.Fatal("handoff passed in GOAGAIN_FD")
+		}
+		b := Inherit(t, again.WithEnvPrefix(testPrefix))
+		if b.Get("web") == nil {
+			t.Fatal("web not inherited")
+		}
+		return
+	}
+	a := again.New(again.WithEnvPrefix(testPrefix))
+	listen(t, &a, "web")
+	defer a.Close()
+	c := StartChild(t, &a, "TestStartChildEnvPrefix")
+	defer c.Stop()
+	c.Wait()
+}
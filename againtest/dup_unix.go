@@ -0,0 +1,9 @@
+//go:build unix
+
+package againtest
+
+import "golang.org/x/sys/unix"
+
+func dup(fd int) (int, error) {
+	return unix.Dup(fd)
+}
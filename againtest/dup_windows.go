@@ -0,0 +1,8 @@
+package againtest
+
+import "syscall"
+
+// dup fails, listeners can't be handed to a child on Windows.
+func dup(fd int) (int, error) {
+	return 0, syscall.EWINDOWS
+}
@@ -0,0 +1,203 @@
+package again
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// UpgradeOutcome is the result of an upgrade attempt.
+type UpgradeOutcome int
+
+const (
+	// UpgradePending means the new process was started but hasn't reported
+	// that it is ready yet.
+	UpgradePending UpgradeOutcome = iota
+	// UpgradeCompleted means the new process took over.
+	UpgradeCompleted
+	// UpgradeFailed means the new process could not be started.
+	UpgradeFailed
+)
+
+func (o UpgradeOutcome) String() string {
+	switch o {
+	case UpgradePending:
+		return "pending"
+	case UpgradeCompleted:
+		return "completed"
+	default:
+		return "failed"
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (o UpgradeOutcome) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// UpgradeRecord describes one upgrade attempt.
+type UpgradeRecord struct {
+	// Trigger is what started the upgrade: "signal" for a signal received
+	// by Wait, "trigger" for Trigger, "control" for adoption over the
+	// control socket, "watch" and "schedule" for WithUpgradeWatch and
+	// WithRestartSchedule and "api" for direct calls.
+	Trigger string `json:"trigger"`
+	// Exec is set for upgrades replacing the process image in place.
+	Exec   bool `json:"exec,omitempty"`
+	OldPID int  `json:"old_pid"`
+	NewPID int  `json:"new_pid,omitempty"`
+	// Binary is the executable started and Checksum its SHA-256.
+	Binary   string         `json:"binary"`
+	Checksum string         `json:"checksum,omitempty"`
+	Outcome  UpgradeOutcome `json:"outcome"`
+	Err      string         `json:"error,omitempty"`
+	Started  time.Time      `json:"started"`
+	// Spawn is how long starting the new process took, Ready how long it
+	// took until it reported that it is ready.
+	Spawn time.Duration `json:"spawn_ns"`
+	Ready time.Duration `json:"ready_ns,omitempty"`
+}
+
+// WithAuditLog appends every finished upgrade record to the file at path as
+// a line of JSON. An upgrade by Exec is written as completed right before
+// the exec, and once more as failed if the exec returns.
+func WithAuditLog(path string) Option {
+	return optionFunc(func(a *Again) {
+		a.auditPath = path
+	})
+}
+
+// History returns the upgrade attempts made by this process, oldest first.
+func (a *Again) History() []UpgradeRecord {
+	a.lc.mu.Lock()
+	defer a.lc.mu.Unlock()
+	return append([]UpgradeRecord(nil), a.lc.history...)
+}
+
+// setUpgradeSource records what triggered the upgrade Wait is about to
+// start.
+func (a *Again) setUpgradeSource(source string) {
+	a.lc.mu.Lock()
+	defer a.lc.mu.Unlock()
+	a.lc.source = source
+}
+
+// upgradeSource returns what triggered the running upgrade.
+func (a *Again) upgradeSource() string {
+	a.lc.mu.Lock()
+	defer a.lc.mu.Unlock()
+	if a.lc.source == "" {
+		return "api"
+	}
+	return a.lc.source
+}
+
+// beginUpgrade appends a pending record for an upgrade starting now and
+// returns its index.
+func (a *Again) beginUpgrade(exec bool) int {
+	r := UpgradeRecord{
+		Trigger: a.upgradeSource(),
+		Exec:    exec,
+		OldPID:  os.Getpid(),
+		Started: time.Now(),
+	}
+	if argv0, err := a.lookPath(); err == nil {
+		r.Binary = argv0
+		r.Checksum = checksum(argv0)
+	}
+	a.lc.mu.Lock()
+	defer a.lc.mu.Unlock()
+	a.lc.history = append(a.lc.history, r)
+	return len(a.lc.history) - 1
+}
+
+// spawned records the outcome of starting the new process of upgrade i.
+func (a *Again) spawned(i, pid int, err error) {
+	a.lc.mu.Lock()
+	r := &a.lc.history[i]
+	r.Spawn = time.Since(r.Started)
+	r.NewPID = pid
+	a.lc.mu.Unlock()
+	if err != nil {
+		a.finishUpgrade(i, err)
+	}
+}
+
+// finishUpgrade records the final outcome of upgrade i and audits it.
+func (a *Again) finishUpgrade(i int, err error) {
+	a.lc.mu.Lock()
+	r := &a.lc.history[i]
+	if err != nil {
+		r.Outcome = UpgradeFailed
+		r.Err = err.Error()
+	} else {
+		r.Outcome = UpgradeCompleted
+		r.Ready = time.Since(r.Started)
+	}
+	done := *r
+	a.lc.mu.Unlock()
+	a.audit(done)
+}
+
+// upgraded completes the pending upgrades started by ForkExec and releases
+// the upgrade lock, because the new process reported that it is ready.
+func (a *Again) upgraded() {
+	a.settleUpgrades(nil)
+}
+
+// settleUpgrades records err as the outcome of the pending upgrades started
+// by ForkExec and releases the upgrade lock.
+func (a *Again) settleUpgrades(err error) {
+	a.lc.mu.Lock()
+	var pending []int
+	for i, r := range a.lc.history {
+		if r.Outcome == UpgradePending && !r.Exec {
+			pending = append(pending, i)
+		}
+	}
+	a.lc.mu.Unlock()
+	for _, i := range pending {
+		a.finishUpgrade(i, err)
+	}
+	a.unlockUpgrade()
+}
+
+// audit appends r to the audit log, if any.
+func (a *Again) audit(r UpgradeRecord) {
+	if a.auditPath == "" {
+		return
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		a.log(slog.LevelError, "again: audit log", "err", err)
+		return
+	}
+	f, err := os.OpenFile(a.auditPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		a.log(slog.LevelError, "again: audit log", "err", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		a.log(slog.LevelError, "again: audit log", "err", err)
+	}
+}
+
+// checksum returns the hex SHA-256 of the file at path, or "" if it can't be
+// read.
+func checksum(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,107 @@
+package again
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// authTimeout bounds how long a peer of the control socket may take to
+// answer the challenge.
+const authTimeout = 10 * time.Second
+
+// WithControlUIDs allows the users uids to use the control socket, instead
+// of only the effective user of this process. The peer's user is taken from
+// the socket, SO_PEERCRED or LOCAL_PEERCRED, which only Linux, macOS and
+// FreeBSD provide; elsewhere peers are only let in with the token of
+// WithControlToken.
+func WithControlUIDs(uids ...int) Option {
+	return optionFunc(func(a *Again) {
+		a.controlUIDs = uids
+	})
+}
+
+// WithControlToken makes the control socket challenge peers to prove they
+// know token, on top of the user check, so only deploy tooling holding it
+// can take the listeners. The token itself never crosses the socket. Adopt
+// answers the challenge with the token of the instance it is called with.
+func WithControlToken(token string) Option {
+	return optionFunc(func(a *Again) {
+		a.controlToken = []byte(token)
+	})
+}
+
+// authorize checks the peer on uc against the allowed users and the token.
+func (a *Again) authorize(uc *net.UnixConn) error {
+	uid, _, err := peerCred(uc)
+	switch {
+	case err != nil && a.controlToken == nil:
+		return fmt.Errorf("%w: peer credentials: %v", ErrUnauthorized, err)
+	case err == nil && !a.controlUID(uid):
+		return fmt.Errorf("%w: uid %d", ErrUnauthorized, uid)
+	}
+	if a.controlToken == nil {
+		return nil
+	}
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	b, err := json.Marshal(handoffMsg{Challenge: nonce})
+	if err != nil {
+		return err
+	}
+	uc.SetDeadline(time.Now().Add(authTimeout))
+	defer uc.SetDeadline(time.Time{})
+	if err := sendFrame(uc, b); err != nil {
+		return err
+	}
+	if b, _, err = recvFrame(uc, 0); err != nil {
+		return err
+	}
+	var req controlRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		return err
+	}
+	if !hmac.Equal(req.MAC, a.mac(nonce)) {
+		return fmt.Errorf("%w: wrong token", ErrUnauthorized)
+	}
+	return nil
+}
+
+// controlUID reports whether uid may use the control socket.
+func (a *Again) controlUID(uid int) bool {
+	if a.controlUIDs == nil {
+		return uid == os.Geteuid()
+	}
+	for _, u := range a.controlUIDs {
+		if u == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// respond answers the challenge of a control socket.
+func (a *Again) respond(uc *net.UnixConn, challenge []byte) error {
+	if a.controlToken == nil {
+		return fmt.Errorf("%w: the control socket wants a token", ErrUnauthorized)
+	}
+	b, err := json.Marshal(controlRequest{MAC: a.mac(challenge)})
+	if err != nil {
+		return err
+	}
+	return sendFrame(uc, b)
+}
+
+// mac returns the answer to challenge.
+func (a *Again) mac(challenge []byte) []byte {
+	h := hmac.New(sha256.New, a.controlToken)
+	h.Write(challenge)
+	return h.Sum(nil)
+}
@@ -0,0 +1,37 @@
+package again
+
+import "path/filepath"
+
+// cgroupRoot is where cgroup v2 is mounted; relative cgroup paths are
+// resolved against it.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupSpec is the cgroup the next generation is started in.
+type cgroupSpec struct {
+	path string
+	fd   int
+}
+
+// WithCgroup starts the next generation forked by ForkExec or a Pool in
+// the cgroup v2 directory path, relative paths being under /sys/fs/cgroup,
+// so its resource limits apply to the generation that serves rather than
+// to, e.g., the cgroup of a deploy tool that started the first one. The
+// child is created in the cgroup with CLONE_INTO_CGROUP; on kernels before
+// 5.7 it is moved there right after it started. Only supported on Linux.
+// Exec ignores it.
+func WithCgroup(path string) Option {
+	return optionFunc(func(a *Again) {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cgroupRoot, path)
+		}
+		a.cgroup = &cgroupSpec{path: path, fd: -1}
+	})
+}
+
+// WithCgroupFD is WithCgroup with an open descriptor of the cgroup
+// directory, which stays owned by the caller.
+func WithCgroupFD(fd int) Option {
+	return optionFunc(func(a *Again) {
+		a.cgroup = &cgroupSpec{fd: fd}
+	})
+}
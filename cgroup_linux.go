@@ -0,0 +1,54 @@
+package again
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// startProcess starts the next generation in the cgroup configured with
+// WithCgroup, if any.
+func (a *Again) startProcess(argv0 string, argv []string, attr *os.ProcAttr) (int, error) {
+	if a.cgroup == nil {
+		return a.sys.StartProcess(argv0, argv, attr)
+	}
+	fd := a.cgroup.fd
+	if a.cgroup.path != "" {
+		f, err := os.Open(a.cgroup.path)
+		if err != nil {
+			return 0, fmt.Errorf("again: cgroup: %w", err)
+		}
+		defer f.Close()
+		fd = int(f.Fd())
+	}
+	attr.Sys.UseCgroupFD = true
+	attr.Sys.CgroupFD = fd
+	pid, err := a.sys.StartProcess(argv0, argv, attr)
+	if !errors.Is(err, unix.ENOSYS) && !errors.Is(err, unix.EINVAL) {
+		return pid, err
+	}
+	// clone3 or CLONE_INTO_CGROUP is missing, move the child instead.
+	attr.Sys.UseCgroupFD = false
+	if pid, err = a.sys.StartProcess(argv0, argv, attr); err != nil {
+		return pid, err
+	}
+	if err := moveToCgroup(fd, pid); err != nil {
+		a.log(slog.LevelWarn, "again: cgroup", "pid", pid, "err", err)
+	}
+	return pid, nil
+}
+
+// moveToCgroup moves pid into the cgroup directory fd.
+func moveToCgroup(fd, pid int) error {
+	procs, err := unix.Openat(fd, "cgroup.procs", unix.O_WRONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(procs)
+	_, err = unix.Write(procs, []byte(strconv.Itoa(pid)))
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package again
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// startProcess starts the next generation; cgroups only exist on Linux.
+func (a *Again) startProcess(argv0 string, argv []string, attr *os.ProcAttr) (int, error) {
+	if a.cgroup != nil {
+		return 0, fmt.Errorf("again: cgroup: %w", errors.ErrUnsupported)
+	}
+	return a.sys.StartProcess(argv0, argv, attr)
+}
@@ -0,0 +1,85 @@
+package again
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// umaskEnv carries the umask the next generation applies, see
+// WithChildUmask.
+const umaskEnv = "UMASK"
+
+// WithChildDir starts the next generation in dir instead of the working
+// directory of this process at the time of the upgrade, so a process that
+// changed into a temporary directory doesn't pass it on forever. Exec
+// changes into dir just before replacing the image.
+func WithChildDir(dir string) Option {
+	return optionFunc(func(a *Again) {
+		a.childDir = dir
+		a.childDirBinary = false
+	})
+}
+
+// WithChildDirOfBinary is WithChildDir with the directory of the binary the
+// next generation runs.
+func WithChildDirOfBinary() Option {
+	return optionFunc(func(a *Again) {
+		a.childDir = ""
+		a.childDirBinary = true
+	})
+}
+
+// WithChildUmask makes the next generation set its umask to mask as the
+// first thing ListenFrom does. The mask travels with the handoff in
+// GOAGAIN_UMASK rather than being set around the fork, where it would
+// apply to files other goroutines create meanwhile. It is ignored on
+// Windows.
+func WithChildUmask(mask int) Option {
+	return optionFunc(func(a *Again) {
+		a.childUmask = &mask
+	})
+}
+
+// childWd returns the working directory of the next generation running
+// the binary argv0.
+func (a *Again) childWd(argv0 string) (string, error) {
+	dir := a.childDir
+	if a.childDirBinary {
+		dir = filepath.Dir(argv0)
+	}
+	if dir == "" {
+		return os.Getwd()
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return "", fmt.Errorf("again: child directory: %w", err)
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("again: child directory %s is not a directory", dir)
+	}
+	return dir, nil
+}
+
+// umaskValue returns the value of GOAGAIN_UMASK for the next generation.
+func (a *Again) umaskValue() string {
+	if a.childUmask == nil {
+		return ""
+	}
+	return fmt.Sprintf("%#o", *a.childUmask)
+}
+
+// applyUmask sets the umask the parent asked for, if any.
+func (a *Again) applyUmask() error {
+	v := a.getenv(umaskEnv)
+	if v == "" {
+		return nil
+	}
+	mask, err := strconv.ParseUint(v, 0, 32)
+	if err != nil {
+		return fmt.Errorf("again: umask %q: %w", v, err)
+	}
+	setUmask(int(mask))
+	return nil
+}
@@ -0,0 +1,144 @@
+package again
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// Codec transfers listeners of one network to the next generation.
+type Codec interface {
+	// Export returns a duplicate of the socket of l to hand over. It is
+	// closed once the next generation has been started.
+	Export(l net.Listener) (*os.File, error)
+	// Import rebuilds a listener from a socket received from the parent.
+	// f is closed once Import returns, so the listener has to use a
+	// duplicate of it.
+	Import(f *os.File) (net.Listener, error)
+}
+
+// WithCodec registers c for listeners whose address network, as returned
+// by Addr().Network(), is network. It takes precedence over the built-in
+// codecs for TCP, unix, UDP, raw and vsock sockets.
+func WithCodec(network string, c Codec) Option {
+	return optionFunc(func(a *Again) {
+		if a.codecs == nil {
+			a.codecs = make(map[string]Codec)
+		}
+		a.codecs[network] = c
+	})
+}
+
+// FdListener is implemented by listeners of types the standard library
+// can't rebuild from a descriptor, e.g. SCTP listeners, so they can be
+// passed to the next generation.
+type FdListener interface {
+	net.Listener
+	// Fd returns the descriptor of the listening socket. The listener
+	// keeps owning it.
+	Fd() (uintptr, error)
+}
+
+// ListenerFactory rebuilds a listener from a socket inherited from the
+// parent. f is closed once the factory returns, so the listener has to use a
+// duplicate of it.
+type ListenerFactory func(f *os.File) (FdListener, error)
+
+// WithListenerFactory registers fn to rebuild inherited listeners whose
+// address network, as returned by Addr().Network(), is network. With a
+// factory for "sctp" and an adapter implementing FdListener, SCTP services
+// survive upgrades like TCP ones. It is a shorthand for WithCodec.
+func WithListenerFactory(network string, fn ListenerFactory) Option {
+	return WithCodec(network, factoryCodec(fn))
+}
+
+// codec returns the codec for listeners of network or nil.
+func (a *Again) codec(network string) Codec {
+	if c := a.codecs[network]; c != nil {
+		return c
+	}
+	switch network {
+	// Parents that didn't record the network only passed stream sockets.
+	case "", "tcp", "tcp4", "tcp6", "unix", "unixpacket":
+		return streamCodec{}
+	case "udp", "udp4", "udp6", "unixgram":
+		return packetCodec{}
+	case "raw":
+		return rawCodec{}
+	}
+	return builtinCodec(network)
+}
+
+// exportFd returns a close-on-exec duplicate of the descriptor of l.
+func exportFd(l net.Listener) (*os.File, error) {
+	fd, err := listenerFd(l)
+	if err != nil {
+		return nil, err
+	}
+	nfd, err := sysOS{}.DupCloexec(fd)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(nfd, ListerName(l)), nil
+}
+
+// streamCodec transfers the stream listeners of the standard library.
+type streamCodec struct{}
+
+func (streamCodec) Export(l net.Listener) (*os.File, error) { return exportFd(l) }
+
+func (streamCodec) Import(f *os.File) (net.Listener, error) {
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, err
+	}
+	switch l.(type) {
+	case *net.TCPListener, *net.UnixListener:
+		return l, nil
+	}
+	l.Close()
+	return nil, fmt.Errorf(
+		"%w: file descriptor is %T not *net.TCPListener or *net.UnixListener",
+		ErrUnsupportedListener, l,
+	)
+}
+
+// packetCodec transfers datagram sockets registered with ListenPacket.
+type packetCodec struct{}
+
+func (packetCodec) Export(l net.Listener) (*os.File, error) { return exportFd(l) }
+
+func (packetCodec) Import(f *os.File) (net.Listener, error) {
+	pc, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, err
+	}
+	return newPacketListener(pc), nil
+}
+
+// factoryCodec adapts a ListenerFactory.
+type factoryCodec ListenerFactory
+
+func (c factoryCodec) Export(l net.Listener) (*os.File, error) { return exportFd(l) }
+
+func (c factoryCodec) Import(f *os.File) (net.Listener, error) {
+	l, err := c(f)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// export returns the socket of s to hand to the next generation, using the
+// codec registered for its network if there is one.
+func (a *Again) export(s *Service) (*os.File, error) {
+	network, _ := parseFdName(s.FdName)
+	if c := a.codecs[network]; c != nil {
+		return c.Export(s.rawListener())
+	}
+	fd, err := a.sys.DupCloexec(s.Descriptor)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(fd, ListerName(s.Listener)), nil
+}
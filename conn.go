@@ -0,0 +1,208 @@
+package again
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats are connection statistics of a single service.
+type Stats struct {
+	// Active is the number of open connections.
+	Active int
+	// Total is the number of connections accepted so far.
+	Total int64
+	// BytesRead and BytesWritten are summed over all connections, open and
+	// closed.
+	BytesRead    int64
+	BytesWritten int64
+	// Oldest is the age of the longest open connection.
+	Oldest time.Duration
+	// Expired is the number of connections closed for exceeding the
+	// maximum age, see WithConnMaxAge.
+	Expired int64
+	// Rejected is the number of connections shed over the limit set with
+	// WithMaxConns.
+	Rejected int64
+}
+
+// Stats returns the connection statistics of s.
+func (s *Service) Stats() Stats {
+	if s.conns == nil {
+		return Stats{}
+	}
+	return s.conns.stats()
+}
+
+// connSet tracks the connections accepted on a service that are still open.
+type connSet struct {
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+
+	total        atomic.Int64
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+	expired      atomic.Int64
+	rejected     atomic.Int64
+
+	// limit caps len(conns); freed is signalled when a connection is
+	// removed.
+	limit connLimit
+	freed chan struct{}
+}
+
+func newConnSet() *connSet {
+	return &connSet{
+		conns: make(map[*trackedConn]struct{}),
+		freed: make(chan struct{}, 1),
+	}
+}
+
+func (cs *connSet) add(c *trackedConn) {
+	cs.total.Add(1)
+	cs.mu.Lock()
+	cs.conns[c] = struct{}{}
+	cs.mu.Unlock()
+}
+
+func (cs *connSet) remove(c *trackedConn) {
+	cs.mu.Lock()
+	delete(cs.conns, c)
+	cs.mu.Unlock()
+	select {
+	case cs.freed <- struct{}{}:
+	default:
+	}
+}
+
+// list returns a snapshot of the open connections.
+func (cs *connSet) list() []*trackedConn {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	l := make([]*trackedConn, 0, len(cs.conns))
+	for c := range cs.conns {
+		l = append(l, c)
+	}
+	return l
+}
+
+func (cs *connSet) stats() Stats {
+	st := Stats{
+		Total:        cs.total.Load(),
+		BytesRead:    cs.bytesRead.Load(),
+		BytesWritten: cs.bytesWritten.Load(),
+		Expired:      cs.expired.Load(),
+		Rejected:     cs.rejected.Load(),
+	}
+	now := time.Now()
+	cs.mu.Lock()
+	st.Active = len(cs.conns)
+	for c := range cs.conns {
+		if age := now.Sub(c.created); age > st.Oldest {
+			st.Oldest = age
+		}
+	}
+	cs.mu.Unlock()
+	return st
+}
+
+// trackingListener registers every accepted connection in a connSet.
+type trackingListener struct {
+	net.Listener
+	conns *connSet
+	gate  *pauseGate
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	c, err := l.accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.conns.wrap(c), nil
+}
+
+// wrap tracks c in cs.
+func (cs *connSet) wrap(c net.Conn) net.Conn {
+	now := time.Now()
+	tc := &trackedConn{Conn: c, set: cs, created: now, jitter: rand.Float64()}
+	tc.lastActive.Store(now.UnixNano())
+	cs.add(tc)
+	return tc
+}
+
+type trackedConn struct {
+	net.Conn
+	set     *connSet
+	created time.Time
+	once    sync.Once
+	// jitter scales the jitter added to the maximum age of c.
+	jitter float64
+
+	// lastActive is the UnixNano time of the last read or write.
+	lastActive atomic.Int64
+}
+
+// idle returns how long c has not read or written anything.
+func (c *trackedConn) idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, c.lastActive.Load()))
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.set.bytesRead.Add(int64(n))
+	c.lastActive.Store(time.Now().UnixNano())
+	return n, err
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.set.bytesWritten.Add(int64(n))
+	c.lastActive.Store(time.Now().UnixNano())
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { c.set.remove(c) })
+	return c.Conn.Close()
+}
+
+// track wraps the listener of s so accepted connections are tracked.
+func (s *Service) track() {
+	switch s.Listener.(type) {
+	case *packetListener, *rawSocket:
+		return
+	}
+	if s.conns == nil {
+		s.conns = newConnSet()
+	}
+	if _, ok := s.Listener.(*trackingListener); !ok {
+		s.Listener = &trackingListener{Listener: s.Listener, conns: s.conns, gate: newPauseGate()}
+	}
+}
+
+// closeConns closes every tracked connection of every service and returns
+// descriptions of the connections that were cut.
+func (a *Again) closeConns() []string {
+	var cut []string
+	a.Range(func(s *Service) {
+		if s.conns == nil {
+			return
+		}
+		for _, c := range s.conns.list() {
+			cut = append(cut, s.Name+" "+c.RemoteAddr().String())
+			c.Close()
+		}
+	})
+	return cut
+}
+
+// activeConns returns the number of open connections over all services.
+func (a *Again) activeConns() int {
+	n := 0
+	a.Range(func(s *Service) {
+		n += s.Stats().Active
+	})
+	return n
+}
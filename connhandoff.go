@@ -0,0 +1,170 @@
+package again
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// connEnv names the descriptor of the socket the next generation receives
+// handed over connections on.
+const connEnv = "CONN_FD"
+
+// ErrNoConnHandoff is returned by HandoffConn and ReceiveConn when there is
+// no socket to pass connections over.
+var ErrNoConnHandoff = errors.New("again: no connection handoff")
+
+// WithConnHandoff connects each forked generation to its parent with a unix
+// socket pair, so the parent can pass established connections to it with
+// HandoffConn instead of closing them. Long-lived protocols such as proxies,
+// MQTT or IRC keep their clients across upgrades this way. The socket is not
+// available to images started by Exec.
+func WithConnHandoff() Option {
+	return optionFunc(func(a *Again) {
+		a.connHandoff = true
+	})
+}
+
+// HandedConn is a connection received from the parent with ReceiveConn.
+type HandedConn struct {
+	net.Conn
+	// Service is the name of the service the connection was accepted on.
+	Service string
+	// State is the application state passed along with the connection.
+	State []byte
+}
+
+// handoffHeader describes a connection on the handoff socket.
+type handoffHeader struct {
+	Service string `json:"service"`
+	State   []byte `json:"state,omitempty"`
+}
+
+// HandoffConn passes c, accepted on the named service, to the last spawned
+// child together with state, and closes it here. The client stays connected;
+// the child picks the connection up with ReceiveConn. Call it once the child
+// is ready, e.g. from an OnSIGQUIT hook, after the connection has stopped
+// reading so no buffered input is lost. HandoffConn blocks while the socket
+// buffer is full. c must expose its descriptor through syscall.Conn, so pass
+// the underlying connection of a tls.Conn with NetConn.
+func (a *Again) HandoffConn(service string, c net.Conn, state []byte) error {
+	a.lc.mu.Lock()
+	uc := a.lc.connOut
+	a.lc.mu.Unlock()
+	if uc == nil {
+		return ErrNoConnHandoff
+	}
+	raw := c
+	if tc, ok := c.(*trackedConn); ok {
+		raw = tc.Conn
+	}
+	sc, ok := raw.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrUnsupportedListener, raw)
+	}
+	b, err := json.Marshal(handoffHeader{Service: service, State: state})
+	if err != nil {
+		return err
+	}
+	if err := sendConn(uc, sc, b); err != nil {
+		return fmt.Errorf("again: handing off connection: %w", err)
+	}
+	return c.Close()
+}
+
+// CloseConnHandoff closes the handoff socket to the child, whose ReceiveConn
+// then returns io.EOF once it has received all connections. It is closed
+// when the process exits otherwise.
+func (a *Again) CloseConnHandoff() error {
+	a.lc.mu.Lock()
+	uc := a.lc.connOut
+	a.lc.connOut = nil
+	a.lc.mu.Unlock()
+	if uc == nil {
+		return nil
+	}
+	return uc.Close()
+}
+
+// ReceiveConn returns the next connection handed over by the parent. It
+// blocks until one arrives and returns io.EOF after the parent closed the
+// handoff socket or exited. If the service is registered, the connection is
+// tracked like the ones it accepts.
+func (a *Again) ReceiveConn() (*HandedConn, error) {
+	a.lc.mu.Lock()
+	uc := a.lc.connIn
+	a.lc.mu.Unlock()
+	if uc == nil {
+		return nil, ErrNoConnHandoff
+	}
+	c, b, err := recvConn(uc)
+	if err != nil {
+		return nil, err
+	}
+	var h handoffHeader
+	if err := json.Unmarshal(b, &h); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("again: receiving connection: %w", err)
+	}
+	if s := a.Get(h.Service); s != nil && s.conns != nil {
+		c = s.conns.wrap(c)
+	}
+	return &HandedConn{Conn: c, Service: h.Service, State: h.State}, nil
+}
+
+// connPair returns the child end of a new handoff socket, keeping the other
+// end to send connections over, or nil if handoff is disabled.
+func (a *Again) connPair() (*os.File, error) {
+	if !a.connHandoff {
+		return nil, nil
+	}
+	parent, child, err := socketPair()
+	if err != nil {
+		return nil, err
+	}
+	a.lc.mu.Lock()
+	old := a.lc.connOut
+	a.lc.connOut = parent
+	a.lc.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return child, nil
+}
+
+// readConnHandoff picks up the handoff socket passed by the parent, if any.
+func (a *Again) readConnHandoff() error {
+	var fd uintptr
+	if _, err := fmt.Sscan(a.getenv(connEnv), &fd); err != nil {
+		return nil
+	}
+	a.unsetenv(connEnv)
+	uc, err := fileUnixConn(fd)
+	if err != nil {
+		return err
+	}
+	a.lc.mu.Lock()
+	a.lc.connIn = uc
+	a.lc.mu.Unlock()
+	return nil
+}
+
+// fileUnixConn returns the unix socket with descriptor fd, which it takes
+// over.
+func fileUnixConn(fd uintptr) (*net.UnixConn, error) {
+	f := os.NewFile(fd, "handoff")
+	defer f.Close()
+	c, err := net.FileConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("again: handoff socket: %w", err)
+	}
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		c.Close()
+		return nil, fmt.Errorf("again: handoff socket: %w", ErrUnsupportedListener)
+	}
+	return uc, nil
+}
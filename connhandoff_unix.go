@@ -0,0 +1,144 @@
+//go:build unix
+
+package again
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketPair returns a connected pair of unix stream sockets; the first is
+// kept, the second is passed to the child.
+func socketPair() (*net.UnixConn, *os.File, error) {
+	syscall.ForkLock.RLock()
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err == nil {
+		unix.CloseOnExec(fds[0])
+		unix.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, nil, os.NewSyscallError("socketpair", err)
+	}
+	f := os.NewFile(uintptr(fds[0]), "handoff")
+	defer f.Close()
+	c, err := net.FileConn(f)
+	if err != nil {
+		unix.Close(fds[1])
+		return nil, nil, err
+	}
+	return c.(*net.UnixConn), os.NewFile(uintptr(fds[1]), "handoff"), nil
+}
+
+// sendConn writes msg prefixed with its length to uc, attaching the
+// descriptor of c to the first byte.
+func sendConn(uc *net.UnixConn, c syscall.Conn, msg []byte) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var werr error
+	if err := rc.Control(func(fd uintptr) {
+		werr = sendFrame(uc, msg, int(fd))
+	}); err != nil {
+		return err
+	}
+	return werr
+}
+
+// recvConn reads a connection and its message sent by sendConn.
+func recvConn(uc *net.UnixConn) (net.Conn, []byte, error) {
+	msg, fds, err := recvFrame(uc, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(fds) != 1 {
+		closeFds(fds)
+		return nil, nil, errors.New("again: handoff frame without a descriptor")
+	}
+	f := os.NewFile(uintptr(fds[0]), "conn")
+	defer f.Close()
+	c, err := net.FileConn(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, msg, nil
+}
+
+// sendFrame writes msg prefixed with its length to uc, attaching fds to the
+// first byte.
+func sendFrame(uc *net.UnixConn, msg []byte, fds ...int) error {
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(msg)))
+	frame = append(frame, msg...)
+	var oob []byte
+	if len(fds) > 0 {
+		oob = unix.UnixRights(fds...)
+	}
+	n, _, err := uc.WriteMsgUnix(frame, oob, nil)
+	if err != nil || n == len(frame) {
+		return err
+	}
+	_, err = uc.Write(frame[n:])
+	return err
+}
+
+// recvFrame reads a message and up to max descriptors sent by sendFrame.
+// The length prefix is read on its own so the descriptors of the next frame
+// are never consumed with this one.
+func recvFrame(uc *net.UnixConn, max int) ([]byte, []int, error) {
+	var size [4]byte
+	oob := make([]byte, unix.CmsgSpace(4*max))
+	n, oobn, _, _, err := uc.ReadMsgUnix(size[:], oob)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n == 0 {
+		return nil, nil, io.EOF
+	}
+	fds, err := parseRights(oob[:oobn])
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.ReadFull(uc, size[n:]); err != nil {
+		closeFds(fds)
+		return nil, nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(uc, msg); err != nil {
+		closeFds(fds)
+		return nil, nil, err
+	}
+	return msg, fds, nil
+}
+
+// parseRights returns the descriptors in the control message b.
+func parseRights(b []byte) ([]int, error) {
+	msgs, err := unix.ParseSocketControlMessage(b)
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	for _, m := range msgs {
+		r, err := unix.ParseUnixRights(&m)
+		if err != nil {
+			continue
+		}
+		fds = append(fds, r...)
+	}
+	for _, fd := range fds {
+		unix.CloseOnExec(fd)
+	}
+	return fds, nil
+}
+
+func closeFds(fds []int) {
+	for _, fd := range fds {
+		unix.Close(fd)
+	}
+}
@@ -0,0 +1,262 @@
+package again
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// opAdopt asks for the listeners of the process serving the control socket.
+const opAdopt = "adopt"
+
+// controlRequest is what a peer sends on the control socket.
+type controlRequest struct {
+	Op string `json:"op"`
+	// PID is the process of the peer.
+	PID int `json:"pid"`
+	// MAC answers the challenge of the control socket, see
+	// WithControlToken.
+	MAC []byte `json:"mac,omitempty"`
+}
+
+// WithControlSocket makes Wait listen on a unix socket at path, where a
+// process started outside of again, e.g. by systemd or a deploy agent, can
+// take over the listeners with Adopt. The adopting process becomes the next
+// generation as if it had been forked: it gets the state, the handoff
+// metadata and, with WithConnHandoff, the connection handoff socket, and
+// once it reports ready this process drains and Wait returns. The socket
+// replaces whatever socket is at path, so each generation takes over the
+// path from the one before.
+func WithControlSocket(path string) Option {
+	return optionFunc(func(a *Again) {
+		a.controlPath = path
+	})
+}
+
+// Adopt takes over the listeners of the again process serving the control
+// socket at path, instead of ListenFrom in a process that was not started
+// by again. The services are registered with a like ListenFrom does. Call
+// Kill once ready to serve to make the old process drain and exit, or
+// AbortHandoff to leave it serving.
+func Adopt(a *Again, path string) error {
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		return fmt.Errorf("again: adopt: %w", err)
+	}
+	uc := c.(*net.UnixConn)
+	b, err := json.Marshal(controlRequest{Op: opAdopt, PID: os.Getpid()})
+	if err != nil {
+		uc.Close()
+		return err
+	}
+	if err := sendFrame(uc, b); err != nil {
+		uc.Close()
+		return fmt.Errorf("again: adopt: %w", err)
+	}
+	if err := a.receiveHandoff(uc); err != nil {
+		return err
+	}
+	return ListenFrom(a, nil)
+}
+
+// startControl serves the control socket until the returned function is
+// called.
+func (a *Again) startControl() func() {
+	if a.controlPath == "" {
+		return func() {}
+	}
+	if fi, err := os.Lstat(a.controlPath); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(a.controlPath)
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: a.controlPath, Net: "unix"})
+	if err != nil {
+		a.log(slog.LevelError, "again: control socket", "err", err)
+		return func() {}
+	}
+	// The next generation owns the path once it took over.
+	l.SetUnlinkOnClose(false)
+	go func() {
+		for {
+			uc, err := l.AcceptUnix()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					a.log(slog.LevelError, "again: control socket", "err", err)
+				}
+				return
+			}
+			go a.serveControl(uc)
+		}
+	}()
+	return func() { l.Close() }
+}
+
+// serveControl answers the request of a peer on the control socket.
+func (a *Again) serveControl(uc *net.UnixConn) {
+	// Peers that don't get through authorize in time don't get to keep
+	// the connection.
+	uc.SetDeadline(time.Now().Add(authTimeout))
+	b, _, err := recvFrame(uc, 0)
+	if err != nil {
+		uc.Close()
+		return
+	}
+	var req controlRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		a.log(slog.LevelError, "again: control socket", "err", err)
+		uc.Close()
+		return
+	}
+	if err := a.authorize(uc); err != nil {
+		a.refuse(uc, err)
+		return
+	}
+	uc.SetDeadline(time.Time{})
+	switch req.Op {
+	case opAdopt:
+		pid, err := peerPID(uc, req.PID)
+		if err != nil {
+			a.refuse(uc, err)
+			return
+		}
+		a.giveAway(uc, pid)
+	default:
+		a.log(slog.LevelWarn, "again: control socket: unknown request", "op", req.Op)
+		uc.Close()
+	}
+}
+
+// peerPID returns the process of the peer on uc, which claims to be pid.
+// The peer becomes our child, which e.g. gets SIGTERM if it fails the
+// health check, so where the kernel tells who it is the claim has to match.
+// Elsewhere peers only get in with the token of WithControlToken and are
+// trusted.
+func peerPID(uc *net.UnixConn, pid int) (int, error) {
+	_, cpid, err := peerCred(uc)
+	switch {
+	case errors.Is(err, syscall.ENOPROTOOPT) || err == nil && cpid == 0:
+		return pid, nil
+	case err != nil:
+		return 0, fmt.Errorf("%w: peer credentials: %v", ErrUnauthorized, err)
+	case cpid != pid:
+		return 0, fmt.Errorf("%w: peer is pid %d, not %d", ErrUnauthorized, cpid, pid)
+	}
+	return cpid, nil
+}
+
+// giveAway hands the services to the peer with pid on uc as the next
+// generation and waits for its answer.
+func (a *Again) giveAway(uc *net.UnixConn, pid int) {
+	if a.State() != Serving {
+		a.refuse(uc, ErrUpgradeInProgress)
+		return
+	}
+	if err := a.lockUpgrade(); err != nil {
+		a.refuse(uc, err)
+		return
+	}
+	a.setUpgradeSource("control")
+	i := a.beginUpgrade(false)
+	a.setUpgradeSource("")
+	a.setState(Upgrading)
+	err := a.sendHandoff(uc)
+	a.spawned(i, pid, err)
+	if err != nil {
+		a.log(slog.LevelError, "again: adopt", "err", err)
+		a.unlockUpgrade()
+		a.setState(Serving)
+		uc.Close()
+		return
+	}
+	a.log(slog.LevelInfo, "handed services over", "pid", pid)
+	if !a.deliver(handoffStarted{child: ChildInfo{PID: pid, Generation: a.generation + 1}}, "handoff") {
+		uc.Close()
+		return
+	}
+	a.watchHandoff(uc, pid, syscall.SIGQUIT)
+}
+
+// refuse tells the peer on uc why it can't have the services.
+func (a *Again) refuse(uc *net.UnixConn, err error) {
+	defer uc.Close()
+	a.log(slog.LevelError, "again: adopt", "err", err)
+	if b, err := json.Marshal(handoffMsg{Err: err.Error()}); err == nil {
+		sendFrame(uc, b)
+	}
+}
+
+// sendHandoff sends all services, the state and the connection handoff
+// socket to the peer on uc, described like for a forked child.
+func (a *Again) sendHandoff(uc *net.UnixConn) error {
+	a.lc.reg.Lock()
+	services := a.snapshot(nil)
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, s := range services {
+		f, err := a.export(s)
+		if err != nil {
+			a.lc.reg.Unlock()
+			return err
+		}
+		files = append(files, f)
+		s.Descriptor = uintptr(len(files) + 2)
+	}
+	a.lc.reg.Unlock()
+	env, err := a.env(services)
+	if err != nil {
+		return err
+	}
+	env[a.envName(stateEnv)] = ""
+	if f, err := a.stateFile(); err != nil {
+		return err
+	} else if f != nil {
+		files = append(files, f)
+		env[a.envName(stateEnv)] = fmt.Sprint(len(files) + 2)
+	}
+	env[a.envName(connEnv)] = ""
+	if f, err := a.connPair(); err != nil {
+		return err
+	} else if f != nil {
+		files = append(files, f)
+		env[a.envName(connEnv)] = fmt.Sprint(len(files) + 2)
+	}
+	if len(files) > maxHandoffFds {
+		return fmt.Errorf("again: %d descriptors exceed the handoff limit of %d", len(files), maxHandoffFds)
+	}
+	env[a.envName("GENERATION")] = fmt.Sprint(a.generation + 1)
+	env[a.envName("PPID")] = fmt.Sprint(syscall.Getpid())
+	env[a.envName("PID")] = ""
+	env[a.envName("PID_START")] = ""
+	env[a.envName("PPID_START")] = startEnv(syscall.Getpid())
+	env[a.envName(execEnv)] = ""
+	env[a.envName(reusePortEnv)] = ""
+	env[a.envName(umaskEnv)] = a.umaskValue()
+	env[a.envName(chrootEnv)] = ""
+	env[a.envName(mountNSEnv)] = ""
+	env[a.envName("SIGNAL")] = fmt.Sprintf("%d", syscall.SIGQUIT)
+	b, err := json.Marshal(handoffMsg{Env: env})
+	if err != nil {
+		return err
+	}
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	if err := sendFrame(uc, b, fds...); err != nil {
+		return err
+	}
+	for _, s := range services {
+		if u := s.unixListener(); u != nil {
+			u.SetUnlinkOnClose(false)
+		}
+	}
+	return nil
+}
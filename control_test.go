@@ -0,0 +1,85 @@
+//go:build unix
+
+package again_test
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TykTechnologies/again"
+)
+
+// serveControl runs Wait of an instance with a web service and a control
+// socket until the test ends and returns the path of the socket.
+func serveControl(t *testing.T, opts ...again.Option) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "control.sock")
+	a := again.New(append(opts,
+		again.WithSignalSource(make(chan os.Signal)),
+		again.WithControlSocket(path),
+	)...)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Listen("web", l); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		again.Wait(&a)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		a.Stop()
+		<-done
+		a.Close()
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for a.State() != again.Serving {
+		if time.Now().After(deadline) {
+			t.Fatal("Wait did not start serving")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return path
+}
+
+func TestControlToken(t *testing.T) {
+	path := serveControl(t, again.WithControlToken("secret"))
+	b := again.New(again.WithControlToken("secret"))
+	if err := again.Adopt(&b, path); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if b.Get("web") == nil {
+		t.Fatal("web not adopted")
+	}
+}
+
+func TestControlWrongToken(t *testing.T) {
+	path := serveControl(t, again.WithControlToken("secret"))
+	b := again.New(again.WithControlToken("guess"))
+	err := again.Adopt(&b, path)
+	if err == nil || !strings.Contains(err.Error(), "wrong token") {
+		t.Fatalf("Adopt with the wrong token returned %v", err)
+	}
+	c := again.New()
+	if err := again.Adopt(&c, path); !errors.Is(err, again.ErrUnauthorized) {
+		t.Fatalf("Adopt without a token returned %v, want %v", err, again.ErrUnauthorized)
+	}
+}
+
+func TestControlUIDs(t *testing.T) {
+	path := serveControl(t, again.WithControlUIDs(os.Geteuid()+1))
+	b := again.New()
+	err := again.Adopt(&b, path)
+	if err == nil || !strings.Contains(err.Error(), again.ErrUnauthorized.Error()) {
+		t.Fatalf("Adopt by a user not allowed returned %v", err)
+	}
+}
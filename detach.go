@@ -0,0 +1,28 @@
+package again
+
+import "os"
+
+// WithDetach starts the processes spawned by ForkExec or a Pool in a new
+// session, detached from the controlling terminal. Use it when the first
+// generation was started from a shell but the upgraded ones should run as
+// proper daemons: closing the terminal or pressing Ctrl-C no longer reaches
+// them. Unless WithChildStdio is set, stdio of the child that refers to a
+// terminal is replaced with /dev/null. Don't combine it with WithProcAttr
+// setting Setpgid or Setctty. On Windows the child is started as a detached
+// process without a console, in a new process group.
+func WithDetach() Option {
+	return optionFunc(func(a *Again) {
+		a.detach = true
+	})
+}
+
+// isTerminal reports whether f looks like a terminal, a character device
+// other than the null device.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(fi, null)
+}
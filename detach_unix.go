@@ -0,0 +1,10 @@
+//go:build unix
+
+package again
+
+import "syscall"
+
+// setDetach makes sys start the process in a new session.
+func setDetach(sys *syscall.SysProcAttr) {
+	sys.Setsid = true
+}
@@ -0,0 +1,12 @@
+package again
+
+import "syscall"
+
+// detachedProcess is the DETACHED_PROCESS creation flag.
+const detachedProcess = 0x00000008
+
+// setDetach makes sys start the process without a console, in a new process
+// group.
+func setDetach(sys *syscall.SysProcAttr) {
+	sys.CreationFlags |= detachedProcess | syscall.CREATE_NEW_PROCESS_GROUP
+}
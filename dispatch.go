@@ -0,0 +1,288 @@
+package again
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"syscall"
+)
+
+// Action is what Wait does when it receives a signal.
+type Action int
+
+const (
+	// ActionNone ignores the signal.
+	ActionNone Action = iota
+	// ActionReload runs the OnSIGHUP hooks.
+	ActionReload
+	// ActionReopen reopens the files registered with WithReopen and runs
+	// the OnSIGUSR1 hooks.
+	ActionReopen
+	// ActionUpgrade upgrades with the configured strategy, or restarts the
+	// workers of a Pool.
+	ActionUpgrade
+	// ActionQuit drains, runs the OnSIGQUIT hooks and returns. After an
+	// upgrade was started it is the child telling us it is ready.
+	ActionQuit
+	// ActionTerminate runs the OnSIGTERM hooks and returns, see
+	// WithTermPolicy.
+	ActionTerminate
+	// ActionInterrupt runs the OnSIGINT hooks and returns.
+	ActionInterrupt
+	// ActionPause stops accepting on all services without exiting. Clients
+	// wait in the listen backlog until ActionResume or, like with nginx,
+	// ActionReload resumes accepting. Map SIGWINCH to it for nginx-style
+	// binary upgrades where the old generation stays around to roll back
+	// to:
+	//
+	//	again.WithSignalAction(syscall.SIGWINCH, again.ActionPause)
+	//
+	// It is not the default since terminals send SIGWINCH when resized.
+	ActionPause
+	// ActionResume accepts on all services again after ActionPause.
+	ActionResume
+
+	// actionAbort fails the upgrade after the child called AbortHandoff.
+	actionAbort
+	// actionAdopted records a process that adopted the services over the
+	// control socket.
+	actionAdopted
+)
+
+// defaultActions maps the signals Wait handles out of the box.
+var defaultActions = map[os.Signal]Action{
+	syscall.SIGHUP:  ActionReload,
+	syscall.SIGINT:  ActionInterrupt,
+	syscall.SIGQUIT: ActionQuit,
+	syscall.SIGTERM: ActionTerminate,
+	sigUSR1:         ActionReopen,
+	SIGUSR2:         ActionUpgrade,
+}
+
+// WithSignalAction makes Wait take act on sig, e.g. ActionUpgrade on SIGHUP
+// for supervisors that only send that. ActionNone stops Wait from handling
+// sig. The signal a child sends with Kill, SIGQUIT or SIGUSR2 with
+// StrategyDouble, has to keep its action.
+func WithSignalAction(sig os.Signal, act Action) Option {
+	return optionFunc(func(a *Again) {
+		if a.actions == nil {
+			a.actions = make(map[os.Signal]Action)
+		}
+		a.actions[sig] = act
+	})
+}
+
+// action returns what to do on sig.
+func (a *Again) action(sig os.Signal) Action {
+	switch sig.(type) {
+	case handoffAborted:
+		return actionAbort
+	case handoffStarted:
+		return actionAdopted
+	}
+	if act, ok := a.actions[sig]; ok {
+		return act
+	}
+	return defaultActions[sig]
+}
+
+// handledSignals returns the signals Wait acts on.
+func (a *Again) handledSignals() []os.Signal {
+	var sigs []os.Signal
+	for sig := range defaultActions {
+		if a.action(sig) != ActionNone {
+			sigs = append(sigs, sig)
+		}
+	}
+	for sig, act := range a.actions {
+		if _, ok := defaultActions[sig]; !ok && act != ActionNone {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs
+}
+
+// waitState is the state of a running Wait.
+type waitState struct {
+	// forked is set once the next generation was spawned.
+	forked bool
+	// source is what delivered the signal being handled.
+	source string
+	// paused is set after ActionPause.
+	paused bool
+}
+
+// step handles an action. It returns true with the result of Wait when Wait
+// should return.
+type step func(a *Again, w *waitState, sig os.Signal) (Result, bool)
+
+// steps is the dispatch table of Wait.
+var steps = map[Action]step{
+	ActionReload:    stepReload,
+	ActionReopen:    stepReopen,
+	ActionUpgrade:   stepUpgrade,
+	ActionQuit:      stepQuit,
+	ActionTerminate: stepTerminate,
+	ActionInterrupt: stepInterrupt,
+	ActionPause:     stepPause,
+	ActionResume:    stepResume,
+	actionAbort:     stepAbort,
+	actionAdopted:   stepAdopted,
+}
+
+// exitCode returns sig as the syscall.Signal reported by Wait.
+func exitCode(sig os.Signal) syscall.Signal {
+	s, _ := sig.(syscall.Signal)
+	return s
+}
+
+func stepReload(a *Again, w *waitState, sig os.Signal) (Result, bool) {
+	if w.paused {
+		stepResume(a, w, sig)
+	}
+	if err := a.runHooks(syscall.SIGHUP); err != nil {
+		a.log(slog.LevelError, "OnSIGHUP", "err", err)
+	}
+	return Result{}, false
+}
+
+func stepReopen(a *Again, w *waitState, sig os.Signal) (Result, bool) {
+	if err := errors.Join(a.reopen(), a.runHooks(sigUSR1)); err != nil {
+		a.log(slog.LevelError, "OnSIGUSR1", "err", err)
+	}
+	return Result{}, false
+}
+
+// stepUpgrade forks and re-execs the first time and, depending on the
+// strategy, execs without forking from then on.
+func stepUpgrade(a *Again, w *waitState, sig os.Signal) (Result, bool) {
+	if p := a.pool(); p != nil {
+		if err := p.RollingRestart(); err != nil {
+			a.log(slog.LevelError, "rolling restart", "err", err)
+		}
+		return Result{}, false
+	}
+	if a.forkHook != nil {
+		a.forkHook()
+	} else if OnForkHook != nil {
+		OnForkHook()
+	}
+	if !w.forked {
+		a.setState(Upgrading)
+		a.traceUpgrade(w.source)
+	} else if a.upgradeStrategy() == StrategyDouble {
+		if err := a.checkHealth(); err != nil {
+			a.rejectChild(w, err)
+			return Result{}, false
+		}
+	}
+	a.setUpgradeSource(w.source)
+	exit, err := a.upgradeStrategy().Upgrade(a, w.forked)
+	a.setUpgradeSource("")
+	if exit {
+		return a.exit(exitCode(sig), w.source, err), true
+	}
+	if nil != err {
+		a.log(slog.LevelError, "upgrade", "err", err)
+		a.endTrace(err)
+		a.setState(Serving)
+		return Result{}, false
+	}
+	w.forked = true
+	return Result{}, false
+}
+
+// stepQuit exits gracefully. When we have forked, this is the child telling
+// us it is ready to serve.
+func stepQuit(a *Again, w *waitState, sig os.Signal) (Result, bool) {
+	if w.forked || a.partialPending() {
+		if err := a.checkHealth(); err != nil {
+			a.rejectChild(w, err)
+			return Result{}, false
+		}
+	}
+	a.upgraded()
+	a.tracedReady(nil)
+	if a.finishPartial() {
+		a.endTrace(nil)
+		return Result{}, false
+	}
+	a.drain()
+	if w.forked && a.Hooks.OnChildReady != nil {
+		a.Hooks.OnChildReady(a, a.child)
+	}
+	end := a.span("again.drain", Attr{"again.pid", os.Getpid()}, Attr{"again.generation", a.generation})
+	err := a.runHooks(syscall.SIGQUIT)
+	end(err)
+	return a.exit(exitCode(sig), w.source, err), true
+}
+
+func stepTerminate(a *Again, w *waitState, sig os.Signal) (Result, bool) {
+	var err error
+	if a.termPolicy == TermDrain {
+		a.drain()
+		err = a.runHooks(syscall.SIGQUIT)
+	}
+	err = errors.Join(err, a.runHooks(syscall.SIGTERM))
+	return a.exit(exitCode(sig), w.source, err), true
+}
+
+func stepInterrupt(a *Again, w *waitState, sig os.Signal) (Result, bool) {
+	return a.exit(exitCode(sig), w.source, a.runHooks(syscall.SIGINT)), true
+}
+
+func stepPause(a *Again, w *waitState, sig os.Signal) (Result, bool) {
+	a.PauseAll()
+	w.paused = true
+	return Result{}, false
+}
+
+func stepResume(a *Again, w *waitState, sig os.Signal) (Result, bool) {
+	a.ResumeAll()
+	w.paused = false
+	return Result{}, false
+}
+
+// stepAbort fails the upgrade the child gave up on and carries on serving.
+func stepAbort(a *Again, w *waitState, sig os.Signal) (Result, bool) {
+	ab := sig.(handoffAborted)
+	err := fmt.Errorf("%w: %s", ErrChildFailed, ab.reason)
+	if !w.forked || ab.pid != a.child.PID {
+		a.emit(Event{Type: EventUpgradeAborted, PID: ab.pid, Err: err})
+		return Result{}, false
+	}
+	a.rollback(w, err)
+	return Result{}, false
+}
+
+// rollback fails the running upgrade because of err and carries on
+// serving.
+func (a *Again) rollback(w *waitState, err error) {
+	a.log(slog.LevelError, "upgrade", "err", err)
+	a.settleUpgrades(err)
+	a.endTrace(err)
+	a.emit(Event{Type: EventUpgradeAborted, PID: a.child.PID, Err: err})
+	w.forked = false
+	a.lc.mu.Lock()
+	a.lc.partial = nil
+	a.lc.mu.Unlock()
+	// spawn left the socket files to the child, they are ours again.
+	if a.ownsSockets() {
+		a.setUnlinkOnClose(true)
+	}
+	a.setState(Serving)
+}
+
+// stepAdopted makes the process that adopted the services over the control
+// socket the next generation, as if we had forked it.
+func stepAdopted(a *Again, w *waitState, sig os.Signal) (Result, bool) {
+	a.child = sig.(handoffStarted).child
+	w.forked = true
+	a.traceUpgrade("control")
+	a.traceReady(a.child)
+	if a.Hooks.OnChildSpawned != nil {
+		a.Hooks.OnChildSpawned(a, a.child)
+	}
+	return Result{}, false
+}
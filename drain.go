@@ -0,0 +1,182 @@
+package again
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"syscall"
+	"time"
+)
+
+// TermPolicy decides what Wait does on SIGTERM.
+type TermPolicy int
+
+const (
+	// TermExit runs the OnSIGTERM hooks and returns right away.
+	TermExit TermPolicy = iota
+	// TermDrain takes the graceful path of SIGQUIT first: the state
+	// changes to Draining, the parent exit timeout and idle reaper start
+	// and the OnSIGQUIT hooks run, followed by the OnSIGTERM hooks. Use it
+	// where the supervisor stops processes with SIGTERM, e.g. Kubernetes.
+	TermDrain
+)
+
+// WithTermPolicy sets the SIGTERM policy. The default is TermExit.
+func WithTermPolicy(p TermPolicy) Option {
+	return optionFunc(func(a *Again) {
+		a.termPolicy = p
+	})
+}
+
+// drain starts draining: connections are reaped and the process is forced
+// to exit as configured. Draining again does nothing until stopDrain.
+func (a *Again) drain() {
+	a.lc.mu.Lock()
+	if a.lc.drained != nil {
+		a.lc.mu.Unlock()
+		return
+	}
+	done := make(chan struct{})
+	a.lc.drained = done
+	a.lc.mu.Unlock()
+	a.setState(Draining)
+	a.startExitTimer()
+	a.startReaper(done)
+}
+
+// stopDrain stops the idle reaper and the exit timer started by drain and
+// reports whether the timer had fired.
+func (a *Again) stopDrain() (timedOut bool) {
+	a.lc.mu.Lock()
+	defer a.lc.mu.Unlock()
+	if a.lc.drained != nil {
+		close(a.lc.drained)
+		a.lc.drained = nil
+	}
+	if a.lc.exitTimer != nil {
+		a.lc.exitTimer.Stop()
+		a.lc.exitTimer = nil
+	}
+	timedOut, a.lc.timedOut = a.lc.timedOut, false
+	return timedOut
+}
+
+// WithParentExitTimeout bounds the time a draining process may take. Once d
+// has elapsed after draining started and Wait has not returned yet, the
+// remaining connections are closed and an EventParentExitTimeout listing
+// them is emitted. Wait then returns an error matching ErrParentExitTimeout
+// once the hooks are done; exiting is up to the application.
+func WithParentExitTimeout(d time.Duration) Option {
+	return optionFunc(func(a *Again) {
+		a.parentExitTimeout = d
+	})
+}
+
+// startExitTimer arms the parent exit deadline if one was configured.
+func (a *Again) startExitTimer() {
+	if a.parentExitTimeout <= 0 {
+		return
+	}
+	t := time.AfterFunc(a.parentExitTimeout, func() {
+		cut := a.closeConns()
+		a.log(slog.LevelWarn, "parent exit timeout reached", "closed", len(cut))
+		a.lc.mu.Lock()
+		a.lc.timedOut = true
+		a.lc.mu.Unlock()
+		a.emit(Event{Type: EventParentExitTimeout, Conns: cut})
+	})
+	a.lc.mu.Lock()
+	a.lc.exitTimer = t
+	a.lc.mu.Unlock()
+}
+
+// minReapInterval bounds how often idle connections are looked for.
+const minReapInterval = 10 * time.Millisecond
+
+// WithIdleReaper closes connections that have not read or written anything
+// for longer than idle once draining begins, until Wait returns. Connections
+// with ongoing I/O are left alone; note that a request whose handler runs
+// longer than idle without touching the connection looks idle too. They are
+// checked every half of idle but at most every 10ms.
+func WithIdleReaper(idle time.Duration) Option {
+	return optionFunc(func(a *Again) {
+		a.idleTimeout = idle
+	})
+}
+
+// startReaper closes idle connections until done is closed.
+func (a *Again) startReaper(done <-chan struct{}) {
+	if a.idleTimeout <= 0 {
+		return
+	}
+	interval := a.idleTimeout / 2
+	if interval < minReapInterval {
+		interval = minReapInterval
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				if cut := a.closeIdle(now); len(cut) > 0 {
+					a.emit(Event{Type: EventConnsReaped, Conns: cut})
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func (a *Again) closeIdle(now time.Time) []string {
+	var cut []string
+	a.Range(func(s *Service) {
+		if s.conns == nil {
+			return
+		}
+		for _, c := range s.conns.list() {
+			if c.idle(now) > a.idleTimeout {
+				cut = append(cut, s.Name+" "+c.RemoteAddr().String())
+				c.Close()
+			}
+		}
+	})
+	return cut
+}
+
+// drainPollInterval is how often ShutdownContext checks for remaining
+// connections.
+const drainPollInterval = 100 * time.Millisecond
+
+// ShutdownContext shuts down without waiting for a signal: it stops accepting
+// on all services, runs the OnSIGQUIT hooks and waits until all tracked
+// connections are closed. If ctx is done first the remaining connections are
+// closed and ctx.Err() is included in the returned error.
+func (a *Again) ShutdownContext(ctx context.Context) error {
+	a.setState(Draining)
+	var errs []error
+	for _, s := range a.closeOrder() {
+		if err := s.Listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, &ServiceError{Service: s.Name, Err: err})
+		}
+	}
+	errs = append(errs, a.runHooks(syscall.SIGQUIT))
+	t := time.NewTicker(drainPollInterval)
+	defer t.Stop()
+	for a.activeConns() > 0 {
+		select {
+		case <-ctx.Done():
+			if cut := a.closeConns(); len(cut) > 0 {
+				a.emit(Event{Type: EventConnsReaped, Conns: cut})
+			}
+			errs = append(errs, ctx.Err())
+			a.setState(Stopped)
+			return errors.Join(errs...)
+		case <-t.C:
+		}
+	}
+	a.setState(Stopped)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,75 @@
+package again_test
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/TykTechnologies/again"
+)
+
+func TestIdleReaperTiny(t *testing.T) {
+	ch := make(chan os.Signal, 1)
+	a := again.New(
+		again.WithSignalSource(ch),
+		again.WithIdleReaper(time.Nanosecond),
+	)
+	a.Hooks.OnSIGQUIT = func(*again.Again) error {
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}
+	ch <- syscall.SIGQUIT
+	if r := again.WaitResult(&a); r.Signal != syscall.SIGQUIT || r.Err != nil {
+		t.Fatalf("Wait returned %v: %v", r.Signal, r.Err)
+	}
+}
+
+func TestParentExitTimeout(t *testing.T) {
+	var timeouts atomic.Int32
+	ch := make(chan os.Signal, 1)
+	a := again.New(
+		again.WithSignalSource(ch),
+		again.WithParentExitTimeout(20*time.Millisecond),
+		again.WithEventHandler(func(e again.Event) {
+			if e.Type == again.EventParentExitTimeout {
+				timeouts.Add(1)
+			}
+		}),
+	)
+	a.Hooks.OnSIGQUIT = func(*again.Again) error {
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	}
+	ch <- syscall.SIGQUIT
+	if r := again.WaitResult(&a); !errors.Is(r.Err, again.ErrParentExitTimeout) {
+		t.Fatalf("Wait returned %v, want %v", r.Err, again.ErrParentExitTimeout)
+	}
+	if n := timeouts.Load(); n != 1 {
+		t.Errorf("got %d EventParentExitTimeout, want 1", n)
+	}
+}
+
+func TestParentExitTimeoutStopped(t *testing.T) {
+	var timeouts atomic.Int32
+	ch := make(chan os.Signal, 1)
+	a := again.New(
+		again.WithSignalSource(ch),
+		again.WithParentExitTimeout(50*time.Millisecond),
+		again.WithEventHandler(func(e again.Event) {
+			if e.Type == again.EventParentExitTimeout {
+				timeouts.Add(1)
+			}
+		}),
+	)
+	ch <- syscall.SIGQUIT
+	if r := again.WaitResult(&a); r.Err != nil {
+		t.Fatalf("Wait returned %v", r.Err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := timeouts.Load(); n != 0 {
+		t.Errorf("exit timer fired %d times after Wait returned", n)
+	}
+}
@@ -0,0 +1,157 @@
+package again
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ValidateFlag is appended to the arguments of the process started by
+// ValidateUpgrade.
+const ValidateFlag = "--again-validate"
+
+// validateEnv marks the process started by ValidateUpgrade.
+const validateEnv = "VALIDATE"
+
+// validateTimeout is how long ValidateUpgrade waits for the new process.
+const validateTimeout = 30 * time.Second
+
+// ErrChecksumMismatch is returned when the binary to upgrade to doesn't have
+// the checksum set with WithChecksumFile.
+var ErrChecksumMismatch = errors.New("again: checksum mismatch")
+
+// WithChecksumFile makes upgrades refuse to start a binary whose SHA-256
+// doesn't match the one in the file at path, in the format of sha256sum.
+// The file is read on every upgrade, so deploys update it along with the
+// binary.
+func WithChecksumFile(path string) Option {
+	return optionFunc(func(a *Again) {
+		a.checksumPath = path
+	})
+}
+
+// Validating reports whether this process was started by ValidateUpgrade
+// and removes ValidateFlag from os.Args, so call it before parsing flags.
+// Such a process should load its configuration as usual and call
+// ListenFrom, which exits with status 0 once the listeners were inherited.
+// Any other exit status fails the validation.
+func Validating() bool {
+	found := false
+	args := os.Args[:0:0]
+	for _, arg := range os.Args {
+		if arg == ValidateFlag {
+			found = true
+			continue
+		}
+		args = append(args, arg)
+	}
+	os.Args = args
+	return found
+}
+
+// ValidateUpgrade goes through an upgrade short of handing over: it
+// resolves the binary, checks that it is executable and has the configured
+// checksum and starts it with ValidateFlag and the listeners, without any
+// connection handoff. The new process has to load its configuration and
+// inherit the listeners and then exits, see Validating; nothing is drained
+// and unix socket files stay with this process. It returns nil if the new
+// process exited with status 0 within 30 seconds.
+func (a *Again) ValidateUpgrade() error {
+	argv0, err := a.binary()
+	if err != nil {
+		return err
+	}
+	if err := executable(argv0); err != nil {
+		return err
+	}
+	// The reaper must not take the exit status from waitPid.
+	a.lc.reaping.Lock()
+	pid, err := a.spawn(nil, map[string]string{
+		a.envName(validateEnv): "1",
+		a.envName("SIGNAL"):    "0",
+	})
+	if err != nil {
+		a.lc.reaping.Unlock()
+		return err
+	}
+	defer a.await(pid)()
+	a.lc.reaping.Unlock()
+	a.log(slog.LevelInfo, "validating upgrade", "pid", pid)
+	type exit struct {
+		ws  syscall.WaitStatus
+		err error
+	}
+	done := make(chan exit, 1)
+	go func() {
+		ws, err := waitPid(pid)
+		done <- exit{ws, err}
+	}()
+	select {
+	case e := <-done:
+		if e.err != nil {
+			return fmt.Errorf("again: validate: %w", e.err)
+		}
+		if !e.ws.Exited() || e.ws.ExitStatus() != 0 {
+			return fmt.Errorf("%w: validation: %v", ErrChildFailed, exitReason(e.ws))
+		}
+		return nil
+	case <-time.After(validateTimeout):
+		a.sys.Kill(pid, syscall.SIGKILL)
+		<-done
+		return fmt.Errorf("%w: validation timed out", ErrChildFailed)
+	}
+}
+
+// binary returns the path of the binary to upgrade to after checking its
+// checksum, if one is configured.
+func (a *Again) binary() (string, error) {
+	argv0, err := a.lookPath()
+	if err != nil || a.checksumPath == "" {
+		return argv0, err
+	}
+	b, err := os.ReadFile(a.checksumPath)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("again: empty checksum file %s", a.checksumPath)
+	}
+	if got := checksum(argv0); !strings.EqualFold(got, fields[0]) {
+		return "", fmt.Errorf("%w: %s has %s, want %s", ErrChecksumMismatch, argv0, got, fields[0])
+	}
+	return argv0, nil
+}
+
+// executable checks that path is a regular file someone may execute.
+func executable(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("again: %s is not a regular file", path)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("again: %s is not executable", path)
+	}
+	return nil
+}
+
+// validated exits after ListenFrom in a process started by ValidateUpgrade.
+// The listeners are closed without removing unix socket files, which
+// belong to the parent.
+func (a *Again) validated() {
+	if a.getenv(validateEnv) == "" {
+		return
+	}
+	a.setUnlinkOnClose(false)
+	a.Close()
+	a.log(slog.LevelInfo, "again: upgrade validated")
+	os.Exit(0)
+}
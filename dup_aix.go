@@ -0,0 +1,24 @@
+package again
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// dupCloexec duplicates fd with close-on-exec set. AIX has no
+// F_DUPFD_CLOEXEC; holding ForkLock keeps a concurrent fork from inheriting
+// the duplicate before the flag is set.
+func dupCloexec(fd uintptr) (uintptr, error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	nfd, err := unix.FcntlInt(fd, unix.F_DUPFD, 0)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := unix.FcntlInt(uintptr(nfd), unix.F_SETFD, unix.FD_CLOEXEC); err != nil {
+		unix.Close(nfd)
+		return 0, err
+	}
+	return uintptr(nfd), nil
+}
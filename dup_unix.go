@@ -0,0 +1,14 @@
+//go:build unix && !aix
+
+package again
+
+import "golang.org/x/sys/unix"
+
+// dupCloexec duplicates fd with close-on-exec set.
+func dupCloexec(fd uintptr) (uintptr, error) {
+	nfd, err := unix.FcntlInt(fd, unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return 0, err
+	}
+	return uintptr(nfd), nil
+}
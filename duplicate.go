@@ -0,0 +1,105 @@
+package again
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RegisterOption changes how Listen and the other registration methods
+// register a service.
+type RegisterOption interface {
+	applyRegister(*registration)
+}
+
+type registerFunc func(*registration)
+
+func (fn registerFunc) applyRegister(r *registration) { fn(r) }
+
+// registration is what the RegisterOptions of a call set.
+type registration struct {
+	force bool
+	group string
+}
+
+// Force registers the service even if another one has the same name,
+// which it replaces, or listens on the same address. The replaced service
+// is not closed.
+func Force() RegisterOption {
+	return registerFunc(func(r *registration) {
+		r.force = true
+	})
+}
+
+// inGroup registers the service as a member of group, whose members may
+// share an address, e.g. SO_REUSEPORT listeners.
+func inGroup(group string) RegisterOption {
+	return registerFunc(func(r *registration) {
+		r.group = group
+	})
+}
+
+// DuplicateError is returned when a service is registered under the name
+// of or on the address of the registered service Existing. Addr is empty
+// if the names clash. It matches ErrDuplicateService or
+// ErrDuplicateAddress with errors.Is.
+type DuplicateError struct {
+	Service  string
+	Existing string
+	Addr     string
+}
+
+func (e *DuplicateError) Error() string {
+	if e.Addr == "" {
+		return fmt.Sprintf("again: service %s already registered", e.Service)
+	}
+	return fmt.Sprintf("again: service %s: %s already registered as %s", e.Service, e.Addr, e.Existing)
+}
+
+// Is makes DuplicateError match ErrDuplicateService or ErrDuplicateAddress.
+func (e *DuplicateError) Is(target error) bool {
+	if e.Addr == "" {
+		return target == ErrDuplicateService
+	}
+	return target == ErrDuplicateAddress
+}
+
+// add registers s unless it duplicates a registered service.
+func (a *Again) add(s *Service, opts []RegisterOption) error {
+	var r registration
+	for _, o := range opts {
+		o.applyRegister(&r)
+	}
+	if r.group != "" {
+		s.Group = r.group
+	}
+	a.lc.reg.Lock()
+	defer a.lc.reg.Unlock()
+	if !r.force {
+		if err := a.duplicate(s); err != nil {
+			return err
+		}
+	}
+	s.owner = a
+	a.services.Store(s.Name, s)
+	return nil
+}
+
+// duplicate returns a DuplicateError if s has the name or the address of a
+// registered service. Members of one group may share an address.
+func (a *Again) duplicate(s *Service) error {
+	if _, ok := a.services.Load(s.Name); ok {
+		return &DuplicateError{Service: s.Name, Existing: s.Name}
+	}
+	network, addr := parseFdName(s.FdName)
+	if addr == "" || strings.HasSuffix(addr, ":0") {
+		return nil
+	}
+	var err error
+	a.Range(func(o *Service) {
+		if err != nil || o.FdName != s.FdName || (s.Group != "" && o.Group == s.Group) {
+			return
+		}
+		err = &DuplicateError{Service: s.Name, Existing: o.Name, Addr: network + ":" + addr}
+	})
+	return err
+}
@@ -0,0 +1,76 @@
+package again
+
+import "os"
+
+// defaultEnvPrefix starts the names of the variables describing the handoff.
+const defaultEnvPrefix = "GOAGAIN"
+
+// WithEnvPrefix names the variables describing the handoff prefix_FD,
+// prefix_PID and so on instead of GOAGAIN_FD, GOAGAIN_PID, ..., so an
+// again-based program can start another one, e.g. a plugin, without either
+// mistaking the other's variables for its own. Every generation has to use
+// the same prefix. Independent instances in one process, e.g. two
+// components each managing their own listeners, need distinct prefixes for
+// the same reason. The package level Child, Kill, KillWithTimeout, Listen
+// and WorkerID always use GOAGAIN; call the methods of the instance instead.
+func WithEnvPrefix(prefix string) Option {
+	return optionFunc(func(a *Again) {
+		a.envPrefix = prefix
+	})
+}
+
+// EnvPrefix returns the prefix of the variables describing the handoff to
+// and from a, see WithEnvPrefix.
+func (a *Again) EnvPrefix() string {
+	return a.prefix()
+}
+
+// EnvName returns the name of the variable carrying the handoff field name,
+// e.g. FD, for a: GOAGAIN_FD unless WithEnvPrefix says otherwise.
+func (a *Again) EnvName(name string) string {
+	return a.envName(name)
+}
+
+// envKey returns the variable name of the protocol field name.
+func envKey(prefix, name string) string {
+	return prefix + "_" + name
+}
+
+// envName returns the variable name of the protocol field name for a.
+func (a *Again) envName(name string) string {
+	return envKey(a.prefix(), name)
+}
+
+// prefix returns the variable prefix of a, GOAGAIN if a is nil.
+func (a *Again) prefix() string {
+	if a == nil || a.envPrefix == "" {
+		return defaultEnvPrefix
+	}
+	return a.envPrefix
+}
+
+// getenv returns the value of the protocol field name. Variables received
+// over the handoff socket are kept on the instance rather than put into the
+// environment, which belongs to the whole process, and take precedence. a
+// may be nil for the package level functions, which only see the
+// environment.
+func (a *Again) getenv(name string) string {
+	if a != nil {
+		a.lc.mu.Lock()
+		v, ok := a.lc.env[a.envName(name)]
+		a.lc.mu.Unlock()
+		if ok {
+			return v
+		}
+	}
+	return os.Getenv(envKey(a.prefix(), name))
+}
+
+// unsetenv forgets the protocol field name once it has been consumed, so
+// subprocesses don't inherit it.
+func (a *Again) unsetenv(name string) {
+	a.lc.mu.Lock()
+	delete(a.lc.env, a.envName(name))
+	a.lc.mu.Unlock()
+	os.Unsetenv(a.envName(name))
+}
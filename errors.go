@@ -0,0 +1,57 @@
+package again
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotChild is returned when an operation needs the process to be a
+	// child started by again, e.g. Kill without a parent in the environment.
+	ErrNotChild = errors.New("again: not a child process")
+	// ErrFdMismatch is returned when descriptors passed by the parent don't
+	// match the services it described.
+	ErrFdMismatch = errors.New("again: descriptor mismatch")
+	// ErrUnsupportedListener is returned for listeners whose descriptor
+	// can't be obtained or transferred.
+	ErrUnsupportedListener = errors.New("again: unsupported listener")
+	// ErrUpgradeInProgress is returned when an upgrade is started while
+	// another one hasn't finished.
+	ErrUpgradeInProgress = errors.New("again: upgrade in progress")
+	// ErrChildFailed is returned when the next generation could not be
+	// started.
+	ErrChildFailed = errors.New("again: child failed")
+	// ErrUnknownService is returned when a service name is not registered.
+	ErrUnknownService = errors.New("again: unknown service")
+	// ErrStopped is returned by Wait after Stop was called.
+	ErrStopped = errors.New("again: stopped")
+	// ErrParentExitTimeout is returned by Wait when the parent exit timeout
+	// passed while draining.
+	ErrParentExitTimeout = errors.New("again: parent exit timeout")
+	// ErrUnauthorized is returned when a peer of the control socket is not
+	// allowed to use it.
+	ErrUnauthorized = errors.New("again: unauthorized")
+	// ErrDuplicateService is matched by a DuplicateError for a service
+	// registered under a name that is taken.
+	ErrDuplicateService = errors.New("again: duplicate service")
+	// ErrDuplicateAddress is matched by a DuplicateError for a service
+	// listening on the address of a registered one.
+	ErrDuplicateAddress = errors.New("again: duplicate address")
+)
+
+// Is makes FdError match ErrFdMismatch.
+func (e *FdError) Is(target error) bool {
+	return target == ErrFdMismatch
+}
+
+// ServiceError is an error that occurred on a specific service.
+type ServiceError struct {
+	Service string
+	Err     error
+}
+
+func (e *ServiceError) Error() string {
+	return fmt.Sprintf("again: service %s: %v", e.Service, e.Err)
+}
+
+func (e *ServiceError) Unwrap() error { return e.Err }
@@ -29,10 +29,11 @@ func main() {
 				log.Fatalln(err)
 			}
 			log.Println("listening on", l.Addr())
-			if err := w.Listen(fmt.Sprintf("service%d", i), l); err != nil {
+			name := fmt.Sprintf("service%d", i)
+			if err := w.Listen(name, l); err != nil {
 				log.Fatalln(err)
 			}
-			go serve(l)
+			go serve(w.GetListener(name))
 		}
 
 	} else {
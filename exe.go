@@ -0,0 +1,98 @@
+package again
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoExecutable is returned when an upgrade can't find the binary to
+// start, before anything is torn down.
+var ErrNoExecutable = errors.New("again: no executable")
+
+// WithExecutable makes upgrades start the binary at path instead of looking
+// up os.Args[0], e.g. a symlink a deploy tool points at each release.
+func WithExecutable(path string) Option {
+	return optionFunc(func(a *Again) {
+		a.exePath = path
+	})
+}
+
+// lookPath returns the binary to upgrade to. os.Args[0] is looked up in
+// PATH if it is a bare name and used if it is absolute. A relative path
+// would resolve against whatever directory the process changed to since,
+// so it and a failed lookup fall back to the path of the running binary;
+// on Linux /proc/self/exe still starts that binary after it was deleted.
+func (a *Again) lookPath() (string, error) {
+	if a.exePath != "" {
+		if err := executable(a.exePath); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrNoExecutable, err)
+		}
+		return a.exePath, nil
+	}
+	argv0 := os.Args[0]
+	var lookErr error
+	if filepath.IsAbs(argv0) || !strings.ContainsRune(argv0, filepath.Separator) {
+		p, err := exec.LookPath(argv0)
+		if err == nil {
+			if err = executable(p); err == nil {
+				return p, nil
+			}
+		}
+		lookErr = err
+	}
+	if p, err := os.Executable(); err == nil {
+		p = strings.TrimSuffix(p, " (deleted)")
+		if executable(p) == nil {
+			return p, nil
+		}
+	}
+	if selfExe != "" && executable(selfExe) == nil {
+		return selfExe, nil
+	}
+	if lookErr != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrNoExecutable, argv0, lookErr)
+	}
+	return "", fmt.Errorf("%w: %s", ErrNoExecutable, argv0)
+}
+
+// image is a binary processes are started from. It is kept open, so it can
+// still be started after a deploy replaced the file at path.
+type image struct {
+	path string
+	f    *os.File
+}
+
+// openImage opens the binary at path.
+func openImage(path string) (*image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoExecutable, err)
+	}
+	return &image{path: path, f: f}, nil
+}
+
+// argv0 returns the path to start the image from: path as long as it is
+// still the same file, the open descriptor otherwise where processes can be
+// started from one.
+func (i *image) argv0() (string, error) {
+	if fi, err := os.Stat(i.path); err == nil {
+		if ofi, err := i.f.Stat(); err == nil && os.SameFile(fi, ofi) {
+			return i.path, nil
+		}
+	}
+	if p := fdExe(i.f); p != "" {
+		return p, nil
+	}
+	return "", fmt.Errorf("%w: %s was replaced", ErrNoExecutable, i.path)
+}
+
+// close releases the image, which may be nil.
+func (i *image) close() {
+	if i != nil {
+		i.f.Close()
+	}
+}
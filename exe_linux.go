@@ -0,0 +1,16 @@
+package again
+
+import (
+	"fmt"
+	"os"
+)
+
+// selfExe names the running binary even after it was deleted.
+const selfExe = "/proc/self/exe"
+
+// fdExe returns a path a child can be started from that names the file f,
+// even after it was deleted. It goes through our pid rather than self, since
+// a child may have its own file at that descriptor when it execs.
+func fdExe(f *os.File) string {
+	return fmt.Sprintf("/proc/%d/fd/%d", os.Getpid(), f.Fd())
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package again
+
+import "os"
+
+// selfExe is only available on Linux.
+const selfExe = ""
+
+// fdExe is only available on Linux.
+func fdExe(f *os.File) string {
+	return ""
+}
@@ -0,0 +1,18 @@
+package again
+
+// execEnv marks a process started by Exec. Such a process is a child without
+// a parent to signal, so Kill does nothing.
+const execEnv = "EXEC"
+
+// WithExecUpgrades makes Wait upgrade on SIGUSR2 by replacing the process
+// image with Exec instead of forking, so the PID never changes. Use it under
+// supervisors like runit or systemd with Restart=always, which lose track of
+// a process that forks its successor. The listeners survive the exec and the
+// new image finds them with ListenFrom as usual; its Kill is a no-op since
+// there is no old process to stop. Connections accepted by the old image are
+// closed by the exec. If the exec fails, Wait keeps serving.
+func WithExecUpgrades() Option {
+	return optionFunc(func(a *Again) {
+		a.strategy = StrategyExec
+	})
+}
@@ -0,0 +1,69 @@
+package again
+
+import (
+	"expvar"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// PublishExpvar publishes the lifecycle counters of a with expvar under
+// namespace, e.g. "again", so they show up in /debug/vars next to
+// memstats:
+//
+//	{"again": {"generation": 3, "pid": 4242, "state": "serving",
+//	  "uptime_seconds": 120.5, "upgrades": 1, "upgrade_failures": 0,
+//	  "child_failures": 0, "conns_active": 12, "conns_total": 3077,
+//	  "services": {"http": {"active": 12, "total": 3077}}}}
+//
+// upgrades and upgrade_failures count the upgrades started by this process,
+// child_failures the children that failed after starting: aborted
+// handoffs, failed health checks and Pool workers exiting with an error.
+// Call it after ListenFrom, once per namespace.
+func (a *Again) PublishExpvar(namespace string) error {
+	if expvar.Get(namespace) != nil {
+		return fmt.Errorf("again: expvar %s already published", namespace)
+	}
+	var childFailures atomic.Int64
+	a.Subscribe(func(e Event) {
+		switch e.Type {
+		case EventUpgradeAborted, EventWorkerExited:
+			if e.Err != nil {
+				childFailures.Add(1)
+			}
+		}
+	})
+	expvar.Publish(namespace, expvar.Func(func() any {
+		var upgrades, failed int
+		for _, r := range a.History() {
+			upgrades++
+			if r.Outcome == UpgradeFailed {
+				failed++
+			}
+		}
+		type serviceVars struct {
+			Active int   `json:"active"`
+			Total  int64 `json:"total"`
+		}
+		services := make(map[string]serviceVars)
+		a.Range(func(s *Service) {
+			st := s.Stats()
+			services[s.Name] = serviceVars{Active: st.Active, Total: st.Total}
+		})
+		st := a.stats()
+		return map[string]any{
+			"generation":       a.generation,
+			"pid":              os.Getpid(),
+			"state":            a.State().String(),
+			"uptime_seconds":   time.Since(a.started).Seconds(),
+			"upgrades":         upgrades,
+			"upgrade_failures": failed,
+			"child_failures":   childFailures.Load(),
+			"conns_active":     st.Active,
+			"conns_total":      st.Total,
+			"services":         services,
+		}
+	}))
+	return nil
+}
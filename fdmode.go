@@ -0,0 +1,57 @@
+package again
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+)
+
+// StdinService is the name of the service Listen adopts from fd 0.
+const StdinService = "stdin"
+
+// WithInheritedFd makes ListenFrom adopt the listening socket on fd as the
+// service name when this process wasn't started by again, e.g. fd 0 under
+// inetd in wait mode or a ucspi-style supervisor. The socket is moved to a
+// new descriptor; if fd is 0, 1 or 2 it is pointed at /dev/null instead, so
+// it isn't handed to the next generation as stdio. Listen does this for a
+// listening socket on fd 0 by itself.
+func WithInheritedFd(name string, fd int) Option {
+	return optionFunc(func(a *Again) {
+		if a.fdServices == nil {
+			a.fdServices = make(map[string]int)
+		}
+		a.fdServices[name] = fd
+	})
+}
+
+// adoptFds registers the services configured with WithInheritedFd.
+func (a *Again) adoptFds() error {
+	names := make([]string, 0, len(a.fdServices))
+	for name := range a.fdServices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fd := a.fdServices[name]
+		f, err := adoptFd(fd)
+		if err != nil {
+			return &FdError{Service: name, Fd: uintptr(fd), Reason: err.Error()}
+		}
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return &FdError{Service: name, Fd: uintptr(fd), Reason: err.Error()}
+		}
+		if err := a.Listen(name, l); err != nil {
+			l.Close()
+			return fmt.Errorf("again: service %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// devNull opens /dev/null for adoptFd.
+func devNull() (*os.File, error) {
+	return os.OpenFile(os.DevNull, os.O_RDWR, 0)
+}
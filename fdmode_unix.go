@@ -0,0 +1,37 @@
+//go:build unix
+
+package again
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// adoptFd takes over the listening socket on fd: it returns a close-on-exec
+// duplicate and closes fd, or points it at /dev/null for stdio.
+func adoptFd(fd int) (*os.File, error) {
+	if v, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ACCEPTCONN); err != nil {
+		return nil, err
+	} else if v == 0 {
+		return nil, fmt.Errorf("socket is not listening")
+	}
+	nfd, err := dupCloexec(uintptr(fd))
+	if err != nil {
+		return nil, err
+	}
+	if fd > 2 {
+		unix.Close(fd)
+	} else if null, err := devNull(); err == nil {
+		unix.Dup2(int(null.Fd()), fd)
+		null.Close()
+	}
+	return os.NewFile(uintptr(nfd), fmt.Sprintf("fd%d", fd)), nil
+}
+
+// listening reports whether fd is a listening socket.
+func listening(fd int) bool {
+	v, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ACCEPTCONN)
+	return err == nil && v != 0
+}
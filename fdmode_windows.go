@@ -0,0 +1,16 @@
+package again
+
+import (
+	"os"
+	"syscall"
+)
+
+// adoptFd fails, Windows can't build listeners from inherited handles.
+func adoptFd(fd int) (*os.File, error) {
+	return nil, syscall.EWINDOWS
+}
+
+// listening reports false, see adoptFd.
+func listening(fd int) bool {
+	return false
+}
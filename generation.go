@@ -0,0 +1,21 @@
+package again
+
+import "time"
+
+// Generation returns the number of handoffs that led to this process: 0 for
+// the first process, 1 for its child and so on. It is only known after
+// ListenFrom.
+func (a *Again) Generation() int {
+	return a.generation
+}
+
+// ParentPID returns the PID of the process that handed its listeners to this
+// one, or 0 if there is none.
+func (a *Again) ParentPID() int {
+	return a.ppid
+}
+
+// StartTime returns when this generation started, i.e. when New was called.
+func (a *Again) StartTime() time.Time {
+	return a.started
+}
@@ -1,3 +1,5 @@
 module github.com/TykTechnologies/again
 
-go 1.12
+go 1.21
+
+require golang.org/x/sys v0.20.0
@@ -0,0 +1,61 @@
+package again
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+)
+
+// GroupHook is called with all services of a group when a signal that has
+// hooks (SIGHUP, SIGUSR1, SIGQUIT, SIGTERM) is received.
+type GroupHook func(a *Again, sig os.Signal, services []*Service) error
+
+// WithGroupHook registers fn for the named group.
+func WithGroupHook(group string, fn GroupHook) Option {
+	return optionFunc(func(a *Again) {
+		if a.groupHooks == nil {
+			a.groupHooks = make(map[string]GroupHook)
+		}
+		a.groupHooks[group] = fn
+	})
+}
+
+// ListenGroup registers several listeners that form one logical service.
+// They are stored as services named "group/0", "group/1", ... with Group set,
+// are passed to the next generation together and can be closed as a unit
+// with CloseGroup.
+func (a *Again) ListenGroup(group string, ls ...net.Listener) error {
+	for i, l := range ls {
+		name := fmt.Sprintf("%s/%d", group, i)
+		if err := a.Listen(name, l, inGroup(group)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Group returns the services of the named group ordered by name.
+func (a *Again) Group(group string) []*Service {
+	var g []*Service
+	a.Range(func(s *Service) {
+		if s.Group == group {
+			g = append(g, s)
+		}
+	})
+	sort.Slice(g, func(i, j int) bool { return g[i].Name < g[j].Name })
+	return g
+}
+
+// CloseGroup closes and removes every service of the named group.
+func (a *Again) CloseGroup(group string) error {
+	var errs []error
+	for _, s := range a.Group(group) {
+		a.Delete(s.Name)
+		if err := s.Listener.Close(); err != nil {
+			errs = append(errs, &ServiceError{Service: s.Name, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,305 @@
+package again
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// handoffEnv names the descriptor of the socket the handoff is read from
+// with WithSocketHandoff.
+const handoffEnv = "HANDOFF_FD"
+
+// maxHandoffFds is the most descriptors a handoff can carry, the SCM_MAX_FD
+// limit of Linux.
+const maxHandoffFds = 253
+
+// ErrNoHandoffSocket is returned by AbortHandoff when the process was not
+// started over a handoff socket.
+var ErrNoHandoffSocket = errors.New("again: no handoff socket")
+
+// WithSocketHandoff hands forked generations their services over a unix
+// socket pair instead of the environment. The service description, the
+// state pipe and the connection handoff socket travel in one message with
+// the descriptors attached, and the environment of the child only names the
+// socket in GOAGAIN_HANDOFF_FD. That keeps the environment short however
+// many services there are and keeps descriptor numbers out of it, which
+// subprocesses inherit. The socket also carries the answer of the child:
+// Kill reports it ready over it and AbortHandoff makes the parent give up
+// the upgrade and carry on serving. The handoff is kept on the instance, so
+// the package level Child and Kill don't see it. Every generation has to
+// use it; images started by Exec still get the handoff through the
+// environment. It is not supported on Windows.
+func WithSocketHandoff() Option {
+	return optionFunc(func(a *Again) {
+		a.socketHandoff = true
+	})
+}
+
+// handoffMsg is the handoff sent to the child.
+type handoffMsg struct {
+	// Env holds the handoff variables. Descriptors are numbered like the
+	// files of a child, the first one attached to the message being 3.
+	Env map[string]string `json:"env"`
+	// Err says why the control socket refused to hand over.
+	Err string `json:"err,omitempty"`
+	// Challenge is sent by a control socket instead of the handoff when it
+	// wants to see the token first.
+	Challenge []byte `json:"challenge,omitempty"`
+}
+
+// handoffAck is what the child answers.
+type handoffAck struct {
+	Ready bool   `json:"ready,omitempty"`
+	Abort string `json:"abort,omitempty"`
+	// Warmup reports Progress and Status of the warmup routine.
+	Warmup   bool    `json:"warmup,omitempty"`
+	Progress float64 `json:"progress,omitempty"`
+	Status   string  `json:"status,omitempty"`
+}
+
+// handoffAborted is delivered to Wait when the child with pid calls
+// AbortHandoff.
+type handoffAborted struct {
+	pid    int
+	reason string
+}
+
+func (h handoffAborted) String() string { return "handoff aborted: " + h.reason }
+func (handoffAborted) Signal()          {}
+
+// handoffStarted is delivered to Wait when child adopted the services over
+// the control socket.
+type handoffStarted struct {
+	child ChildInfo
+}
+
+func (h handoffStarted) String() string { return fmt.Sprint("handed over to ", h.child.PID) }
+func (handoffStarted) Signal()          {}
+
+// handoffSocket moves the handoff variables in env and the descriptors
+// after stdio in files onto a new socket pair. It returns the files and
+// variables to start the child with and a function that sends the handoff
+// to the child with pid once it has been started, or just cleans up if pid
+// is 0.
+func (a *Again) handoffSocket(files []*os.File, env map[string]string) ([]*os.File, map[string]string, func(pid int), error) {
+	passed := files[3:]
+	if len(passed) > maxHandoffFds {
+		return nil, nil, nil, fmt.Errorf("again: %d descriptors exceed the handoff limit of %d", len(passed), maxHandoffFds)
+	}
+	msg := handoffMsg{Env: make(map[string]string)}
+	set := make(map[string]string)
+	for k, v := range env {
+		if strings.HasPrefix(k, a.envName("")) {
+			msg.Env[k] = v
+		} else {
+			set[k] = v
+		}
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	parent, child, err := socketPair()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	set[a.envName(handoffEnv)] = "3"
+	var sig syscall.Signal
+	fmt.Sscan(msg.Env[a.envName("SIGNAL")], &sig)
+	send := func(pid int) {
+		defer func() {
+			for _, f := range passed {
+				f.Close()
+			}
+		}()
+		if pid == 0 {
+			parent.Close()
+			return
+		}
+		fds := make([]int, len(passed))
+		for i, f := range passed {
+			fds[i] = int(f.Fd())
+		}
+		if err := sendFrame(parent, b, fds...); err != nil {
+			a.log(slog.LevelError, "again: handoff", "err", err)
+			parent.Close()
+			return
+		}
+		go a.watchHandoff(parent, pid, sig)
+	}
+	return append(files[:3:3], child), set, send, nil
+}
+
+// watchHandoff turns the answers of the child with pid into signals for
+// Wait until the child closes the socket. A child closing it before
+// answering aborts the handoff, unless it isn't expected to report ready.
+func (a *Again) watchHandoff(uc *net.UnixConn, pid int, sig syscall.Signal) {
+	defer uc.Close()
+	answered := false
+	for {
+		b, _, err := recvFrame(uc, 0)
+		if err != nil {
+			if !answered && sig != 0 {
+				a.deliver(handoffAborted{pid: pid, reason: "handoff socket closed"}, "handoff")
+			}
+			return
+		}
+		var ack handoffAck
+		if err := json.Unmarshal(b, &ack); err != nil {
+			a.log(slog.LevelError, "again: handoff", "err", err)
+			continue
+		}
+		var s os.Signal
+		switch {
+		case ack.Warmup:
+			a.emit(Event{Type: EventWarmupProgress, PID: pid, Progress: ack.Progress, Status: ack.Status})
+			continue
+		case ack.Abort != "":
+			s = handoffAborted{pid: pid, reason: ack.Abort}
+		case ack.Ready && sig != 0:
+			s = sig
+		default:
+			continue
+		}
+		answered = true
+		if !a.deliver(s, "handoff") {
+			return
+		}
+	}
+}
+
+// readHandoff reads the handoff from the socket named in the environment,
+// if any, and keeps its variables for getenv.
+func (a *Again) readHandoff() error {
+	var fd uintptr
+	if _, err := fmt.Sscan(a.getenv(handoffEnv), &fd); err != nil {
+		return nil
+	}
+	a.unsetenv(handoffEnv)
+	uc, err := fileUnixConn(fd)
+	if err != nil {
+		return err
+	}
+	return a.receiveHandoff(uc)
+}
+
+// receiveHandoff reads the handoff from uc and keeps its variables for
+// getenv. uc is kept to answer over.
+func (a *Again) receiveHandoff(uc *net.UnixConn) error {
+	b, fds, err := recvFrame(uc, maxHandoffFds)
+	if err != nil {
+		uc.Close()
+		return fmt.Errorf("again: handoff: %w", err)
+	}
+	var msg handoffMsg
+	if err := json.Unmarshal(b, &msg); err != nil {
+		closeFds(fds)
+		uc.Close()
+		return fmt.Errorf("again: handoff: %w", err)
+	}
+	if msg.Err != "" {
+		closeFds(fds)
+		uc.Close()
+		return fmt.Errorf("again: handoff refused: %s", msg.Err)
+	}
+	if msg.Challenge != nil {
+		closeFds(fds)
+		if err := a.respond(uc, msg.Challenge); err != nil {
+			uc.Close()
+			return err
+		}
+		return a.receiveHandoff(uc)
+	}
+	for k, v := range msg.Env {
+		switch k {
+		case a.envName("FD"), a.envName(stateEnv), a.envName(connEnv):
+			if msg.Env[k], err = remapFds(v, fds); err != nil {
+				closeFds(fds)
+				uc.Close()
+				return err
+			}
+		}
+	}
+	a.lc.mu.Lock()
+	a.lc.env = msg.Env
+	a.lc.handoff = uc
+	a.lc.mu.Unlock()
+	return nil
+}
+
+// remapFds replaces the descriptor numbers in the comma separated list v,
+// counted from 3, with the received descriptors fds.
+func remapFds(v string, fds []int) (string, error) {
+	if v == "" {
+		return v, nil
+	}
+	l := strings.Split(v, ",")
+	for i, s := range l {
+		if s == "" {
+			continue
+		}
+		var n int
+		if _, err := fmt.Sscan(s, &n); err != nil {
+			return "", err
+		}
+		if n < 3 || n-3 >= len(fds) {
+			return "", fmt.Errorf("%w: descriptor %d not in handoff", ErrFdMismatch, n)
+		}
+		l[i] = fmt.Sprint(fds[n-3])
+	}
+	return strings.Join(l, ","), nil
+}
+
+// answer sends ack to the parent over the handoff socket.
+func (a *Again) answer(ack handoffAck) error {
+	a.lc.mu.Lock()
+	uc := a.lc.handoff
+	a.lc.mu.Unlock()
+	if uc == nil {
+		return ErrNoHandoffSocket
+	}
+	b, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	return sendFrame(uc, b)
+}
+
+// ackReady tells the parent that we are ready over the handoff socket, if
+// pid is the parent and there is one. It reports whether that worked.
+func (a *Again) ackReady(pid int) bool {
+	if pid != a.ppid {
+		return false
+	}
+	err := a.answer(handoffAck{Ready: true})
+	if err != nil && err != ErrNoHandoffSocket {
+		a.log(slog.LevelError, "again: handoff", "err", err)
+	}
+	return err == nil
+}
+
+// AbortHandoff tells the parent that this process gives up taking over,
+// because of err. The parent fails the upgrade and carries on serving, so
+// exit afterwards; unix socket files are left to the parent. It returns
+// ErrNoHandoffSocket unless the parent used WithSocketHandoff.
+func (a *Again) AbortHandoff(err error) error {
+	if err := a.answer(handoffAck{Abort: err.Error()}); err != nil {
+		return err
+	}
+	a.setUnlinkOnClose(false)
+	return nil
+}
+
+// abortHandoff is AbortHandoff for failures of ListenFrom, which returns
+// err itself.
+func (a *Again) abortHandoff(err error) {
+	if aerr := a.AbortHandoff(err); aerr != nil && aerr != ErrNoHandoffSocket {
+		a.log(slog.LevelError, "again: abort handoff", "err", aerr)
+	}
+}
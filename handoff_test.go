@@ -0,0 +1,116 @@
+//go:build unix
+
+package again_test
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/TykTechnologies/again"
+	"github.com/TykTechnologies/again/againtest"
+)
+
+// handoffChild starts an upgrade of a parent using WithSocketHandoff and
+// takes the handoff in this process, as the child would. The parent runs
+// Wait on ch and sends its result to done.
+func handoffChild(t *testing.T, opts ...again.Option) (parent, child *again.Again, done <-chan again.Result) {
+	t.Helper()
+	sys := &fakeOS{passed: make(chan []int, 1)}
+	ch := make(chan os.Signal, 1)
+	a := again.New(append(opts,
+		again.WithOS(sys),
+		again.WithSignalSource(ch),
+		again.WithSocketHandoff(),
+	)...)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Listen("web", l); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	res := make(chan again.Result, 1)
+	go func() { res <- again.WaitResult(&a) }()
+	ch <- again.SIGUSR2
+
+	var fds []int
+	select {
+	case fds = <-sys.passed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no child started")
+	}
+	// Only the socket is passed, the listener travels over it.
+	if len(fds) != 1 {
+		t.Fatalf("child gets %d descriptors, want the handoff socket only", len(fds))
+	}
+	for _, kv := range sys.started[0].Env {
+		if strings.HasPrefix(kv, a.EnvName("FD")+"=") {
+			t.Errorf("descriptors passed in the environment: %s", kv)
+		}
+	}
+	t.Setenv(a.EnvName("HANDOFF_FD"), strconv.Itoa(fds[0]))
+	b := again.New(again.WithSocketHandoff())
+	if err := again.ListenFrom(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	if b.Get("web") == nil {
+		t.Fatal("web not handed over")
+	}
+	againtest.AssertAccepts(t, "tcp", b.Get("web").Listener.Addr().String())
+	return &a, &b, res
+}
+
+func TestSocketHandoffReady(t *testing.T) {
+	_, b, done := handoffChild(t)
+	if err := b.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-done:
+		if r.Signal != syscall.SIGQUIT || r.Child.PID != 4242 || r.Err != nil {
+			t.Fatalf("Wait returned %v with child %+v: %v, want SIGQUIT with child 4242", r.Signal, r.Child, r.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("parent did not take the ready answer")
+	}
+}
+
+func TestSocketHandoffAbort(t *testing.T) {
+	aborted := make(chan error, 1)
+	a, b, done := handoffChild(t, again.WithEventHandler(func(e again.Event) {
+		if e.Type == again.EventUpgradeAborted {
+			aborted <- e.Err
+		}
+	}))
+	if err := b.AbortHandoff(errors.New("not today")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-aborted:
+		if !errors.Is(err, again.ErrChildFailed) || !strings.Contains(err.Error(), "not today") {
+			t.Errorf("upgrade aborted with %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("parent did not take the abort")
+	}
+	// The state changes right after the event.
+	deadline := time.Now().Add(5 * time.Second)
+	for a.State() != again.Serving {
+		if time.Now().After(deadline) {
+			t.Fatalf("parent state %v after abort, want %v", a.State(), again.Serving)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	a.Stop()
+	if r := <-done; r.Err != again.ErrStopped {
+		t.Fatalf("Wait returned %v, want %v", r.Err, again.ErrStopped)
+	}
+}
@@ -0,0 +1,108 @@
+package again
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// healthPollInterval is how often HTTPCheck retries.
+const healthPollInterval = 100 * time.Millisecond
+
+// HealthCheck tells whether the next generation is healthy. It is called
+// once child reported ready and returns nil as soon as the child passed, or
+// an error once ctx is done.
+type HealthCheck func(ctx context.Context, child ChildInfo) error
+
+// WithHealthCheck makes this process run check against a child that
+// reported ready, before it drains. Reporting ready only means the child
+// got as far as calling Kill. If check fails or doesn't pass within timeout
+// the child is sent SIGTERM, the upgrade is recorded as failed and this
+// process carries on serving. A timeout <= 0 means DefaultHealthTimeout;
+// Wait handles no other signal while the check runs. The check gates the
+// SIGQUIT of the child, also after UpgradeServices, or its SIGUSR2 with
+// StrategyDouble, and adoption over the control socket.
+func WithHealthCheck(check HealthCheck, timeout time.Duration) Option {
+	return optionFunc(func(a *Again) {
+		a.healthCheck = check
+		a.healthTimeout = timeout
+	})
+}
+
+// HTTPCheck returns a HealthCheck polling url until it answers with a 2xx
+// status. Point it at an address only the child serves, e.g. an admin
+// listener it opens itself; the inherited listeners are served by both
+// generations until this one drains.
+func HTTPCheck(url string) HealthCheck {
+	return func(ctx context.Context, child ChildInfo) error {
+		var last error
+		for {
+			last = probeHTTP(ctx, url)
+			if last == nil {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%w: %v", ctx.Err(), last)
+			case <-time.After(healthPollInterval):
+			}
+		}
+	}
+}
+
+// probeHTTP requests url once.
+func probeHTTP(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// checkHealth runs the health check against the last child, if any is
+// configured.
+func (a *Again) checkHealth() error {
+	if a.healthCheck == nil {
+		return nil
+	}
+	timeout := a.healthTimeout
+	if timeout <= 0 {
+		timeout = DefaultHealthTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// Don't rely on the check honouring ctx, Wait is blocked meanwhile.
+	errc := make(chan error, 1)
+	go func() { errc <- a.healthCheck(ctx, a.child) }()
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("%w: health check: %w", ErrChildFailed, err)
+	}
+	return nil
+}
+
+// rejectChild stops the last child after it failed the health check and
+// rolls the upgrade back.
+func (a *Again) rejectChild(w *waitState, err error) {
+	a.log(slog.LevelWarn, "sending signal", "signal", syscall.SIGTERM, "pid", a.child.PID)
+	if kerr := a.sys.Kill(a.child.PID, syscall.SIGTERM); kerr != nil {
+		a.log(slog.LevelError, "again: stopping unhealthy child", "err", kerr)
+	}
+	a.rollback(w, err)
+}
@@ -0,0 +1,88 @@
+package again
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// defaultHookConcurrency is the number of hooks run in parallel when no
+// WithHookConcurrency option is given.
+const defaultHookConcurrency = 8
+
+// hook returns the hook registered in h for sig or nil.
+func (h Hooks) hook(sig os.Signal) func(*Again) error {
+	switch sig {
+	case syscall.SIGHUP:
+		return h.OnSIGHUP
+	case sigUSR1:
+		return h.OnSIGUSR1
+	case syscall.SIGQUIT:
+		return h.OnSIGQUIT
+	case syscall.SIGTERM:
+		return h.OnSIGTERM
+	case syscall.SIGINT:
+		return h.OnSIGINT
+	}
+	return nil
+}
+
+// runHooks executes the instance hook and every service and group hook
+// registered for sig concurrently and returns the joined errors. Errors from
+// service and group hooks are prefixed with the service or group name.
+func (a *Again) runHooks(sig os.Signal) error {
+	type job struct {
+		name string
+		fn   func(*Again) error
+	}
+	var jobs []job
+	if fn := a.Hooks.hook(sig); fn != nil {
+		jobs = append(jobs, job{fn: fn})
+	}
+	a.Range(func(s *Service) {
+		if fn := s.Hooks.hook(sig); fn != nil {
+			jobs = append(jobs, job{name: s.Name, fn: fn})
+		}
+	})
+	for group, fn := range a.groupHooks {
+		if services := a.Group(group); len(services) > 0 {
+			fn := fn
+			jobs = append(jobs, job{name: group, fn: func(a *Again) error {
+				return fn(a, sig, services)
+			}})
+		}
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	n := a.hookConcurrency
+	if n == 0 {
+		n = defaultHookConcurrency
+	}
+	if n < 0 || n > len(jobs) {
+		n = len(jobs)
+	}
+	errs := make([]error, len(jobs))
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i, j := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, j job) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := j.fn(a); err != nil {
+				if j.name != "" {
+					err = fmt.Errorf("%s: %w", j.name, err)
+				}
+				errs[i] = err
+			}
+		}(i, j)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
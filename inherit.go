@@ -0,0 +1,111 @@
+package again
+
+import (
+	"net"
+)
+
+// InheritPolicy decides what happens when a listener can't be rebuilt from
+// the descriptor inherited from the parent.
+type InheritPolicy int
+
+const (
+	// InheritFail makes ListenFrom return the error.
+	InheritFail InheritPolicy = iota
+	// InheritSkip drops the service and carries on with the others.
+	InheritSkip
+	// InheritRebind binds the recorded address afresh. This only succeeds
+	// when the parent no longer holds the address or the socket allows it,
+	// e.g. with SO_REUSEPORT.
+	InheritRebind
+)
+
+// InheritOutcome is what happened to a single service in ListenFrom.
+type InheritOutcome int
+
+const (
+	// Inherited means the listener was rebuilt from the parent descriptor.
+	Inherited InheritOutcome = iota
+	// Skipped means the service was dropped under InheritSkip.
+	Skipped
+	// Rebound means the address was bound afresh under InheritRebind.
+	Rebound
+	// Failed means the service could not be set up at all.
+	Failed
+)
+
+func (o InheritOutcome) String() string {
+	switch o {
+	case Inherited:
+		return "inherited"
+	case Skipped:
+		return "skipped"
+	case Rebound:
+		return "rebound"
+	default:
+		return "failed"
+	}
+}
+
+// InheritResult reports how a service passed by the parent was handled.
+type InheritResult struct {
+	Service string
+	Outcome InheritOutcome
+	// Err is the inheritance error for anything but Inherited.
+	Err error
+}
+
+// WithInheritPolicy sets the policy used for services without their own
+// policy. The default is InheritFail.
+func WithInheritPolicy(p InheritPolicy) Option {
+	return optionFunc(func(a *Again) {
+		a.policy = p
+	})
+}
+
+// WithServiceInheritPolicy sets the inherit policy of the named service.
+func WithServiceInheritPolicy(name string, p InheritPolicy) Option {
+	return optionFunc(func(a *Again) {
+		if a.policies == nil {
+			a.policies = make(map[string]InheritPolicy)
+		}
+		a.policies[name] = p
+	})
+}
+
+// InheritResults returns the outcome for every service passed by the parent,
+// in the order they were processed by ListenFrom.
+func (a *Again) InheritResults() []InheritResult {
+	return append([]InheritResult(nil), a.inherited...)
+}
+
+func (a *Again) inheritPolicy(name string) InheritPolicy {
+	if p, ok := a.policies[name]; ok {
+		return p
+	}
+	return a.policy
+}
+
+// rebind binds the address recorded in the FdName of s, with the
+// ListenConfig of s if it has one.
+func (a *Again) rebind(s *Service) error {
+	network, addr := parseFdName(s.FdName)
+	if network == "" {
+		return &FdError{Service: s.Name, Fd: s.Descriptor, Reason: "no address recorded"}
+	}
+	var lc net.ListenConfig
+	if s.lc != nil {
+		lc = *s.lc
+	}
+	l, err := listen(&lc, network, addr)
+	if err != nil {
+		return err
+	}
+	fd, err := listenerFd(l)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	s.Listener = l
+	s.Descriptor = fd
+	return a.restoreSockOpts(s)
+}
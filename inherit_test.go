@@ -0,0 +1,126 @@
+//go:build unix
+
+package again_test
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/TykTechnologies/again"
+	"github.com/TykTechnologies/again/againtest"
+)
+
+// TestListenFromFdReuse checks that nothing closes the inherited descriptor
+// number once ListenFrom is done with it, when a file reuses it.
+func TestListenFromFdReuse(t *testing.T) {
+	a := again.New()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Listen("web", l); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	env, err := a.Env()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := strconv.Atoi(env[a.EnvName("FD")])
+	if err != nil {
+		t.Fatal(err)
+	}
+	inherited, err := unix.Dup(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env[a.EnvName("FD")] = strconv.Itoa(inherited)
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+
+	b := again.New()
+	if err := again.ListenFrom(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b.Get("web") == nil {
+		t.Fatal("web not inherited")
+	}
+	if _, err := unix.FcntlInt(uintptr(inherited), unix.F_GETFD, 0); err != unix.EBADF {
+		t.Fatalf("inherited descriptor %d still open: %v", inherited, err)
+	}
+
+	// Put a file on the number the listener came in on.
+	f, err := os.CreateTemp(t.TempDir(), "reuse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reused := f
+	if int(f.Fd()) != inherited {
+		if err := unix.Dup2(int(f.Fd()), inherited); err != nil {
+			t.Fatal(err)
+		}
+		reused = os.NewFile(uintptr(inherited), f.Name())
+		defer reused.Close()
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reused.WriteString("still open"); err != nil {
+		t.Fatalf("file on reused descriptor %d closed: %v", inherited, err)
+	}
+	againtest.AssertAccepts(t, "tcp", l.Addr().String())
+}
+
+// TestRebindListenConfig checks that a service bound afresh in ListenFrom
+// runs the Control of the ListenConfig given for it.
+func TestRebindListenConfig(t *testing.T) {
+	a := again.New()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Listen("web", l); err != nil {
+		t.Fatal(err)
+	}
+	env, err := a.Env()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+	// A descriptor that is not open makes inheriting fail.
+	env[a.EnvName("FD")] = "1023"
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+
+	var controlled bool
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			controlled = true
+			return nil
+		},
+	}
+	b := again.New(
+		again.WithServiceInheritPolicy("web", again.InheritRebind),
+		again.WithListenConfig("web", lc),
+	)
+	if err := again.ListenFrom(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if res := b.InheritResults(); len(res) != 1 || res[0].Outcome != again.Rebound {
+		t.Fatalf("inherit results %v, want web rebound", res)
+	}
+	if !controlled {
+		t.Error("Control of the ListenConfig did not run")
+	}
+	againtest.AssertAccepts(t, "tcp", l.Addr().String())
+}
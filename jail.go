@@ -0,0 +1,99 @@
+package again
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// chrootEnv and mountNSEnv tell a generation that it already runs in the
+// chroot or mount namespace configured with WithChroot or
+// WithMountNamespace, so it doesn't enter another one for its successor.
+const (
+	chrootEnv  = "CHROOT"
+	mountNSEnv = "MOUNTNS"
+)
+
+// WithChroot starts the next generation forked by ForkExec or a Pool
+// chrooted to dir. The listeners are inherited as descriptors, so they
+// keep working whatever the jail contains, but the binary has to be inside
+// dir, as does anything the child opens later; unix socket files are
+// removed by their path inside the jail, if at all. Generations started in
+// the jail stay in it and don't chroot again. It needs root, or
+// CAP_SYS_CHROOT on Linux, and is not supported on Windows. Exec ignores
+// it.
+func WithChroot(dir string) Option {
+	return optionFunc(func(a *Again) {
+		a.chroot = filepath.Clean(dir)
+	})
+}
+
+// WithMountNamespace starts the next generation forked by ForkExec or a
+// Pool in a new mount namespace with private mounts, so it can mount or
+// unmount, e.g. in an OnChildSpawned hook via /proc/PID/ns/mnt or from the
+// child itself, without affecting the host. Generations started in it
+// stay in it. It needs CAP_SYS_ADMIN and is only supported on Linux. Exec
+// ignores it.
+func WithMountNamespace() Option {
+	return optionFunc(func(a *Again) {
+		a.mountNS = true
+	})
+}
+
+// jailPaths returns the binary argv0 and the working directory wd as seen
+// from the chroot the next generation is started in, if any, and sets the
+// variables telling it where it runs in env.
+func (a *Again) jailPaths(argv0, wd string, env map[string]string) (string, string, error) {
+	env[a.envName(mountNSEnv)] = a.getenv(mountNSEnv)
+	if a.mountNS {
+		if err := mountNSSupported(); err != nil {
+			return "", "", err
+		}
+		env[a.envName(mountNSEnv)] = "1"
+	}
+	if a.chroot == "" || a.chrooted() {
+		env[a.envName(chrootEnv)] = a.getenv(chrootEnv)
+		return argv0, wd, nil
+	}
+	if err := chrootSupported(); err != nil {
+		return "", "", err
+	}
+	bin, ok := inside(a.chroot, argv0)
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s is outside of the chroot %s", ErrNoExecutable, argv0, a.chroot)
+	}
+	dir, ok := inside(a.chroot, wd)
+	if !ok {
+		dir = "/"
+	}
+	env[a.envName(chrootEnv)] = a.chroot
+	return bin, dir, nil
+}
+
+// chrooted reports whether this process already runs in the chroot.
+func (a *Again) chrooted() bool {
+	return a.getenv(chrootEnv) == a.chroot
+}
+
+// jailAttr returns whether the next generation has to enter the chroot and
+// a new mount namespace.
+func (a *Again) jailAttr() (chroot string, mountNS bool) {
+	if a.chroot != "" && !a.chrooted() {
+		chroot = a.chroot
+	}
+	return chroot, a.mountNS && a.getenv(mountNSEnv) == ""
+}
+
+// inside returns path relative to root as an absolute path, if it is in
+// root.
+func inside(root, path string) (string, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.Join("/", rel), true
+}
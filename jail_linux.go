@@ -0,0 +1,15 @@
+package again
+
+import "syscall"
+
+func chrootSupported() error  { return nil }
+func mountNSSupported() error { return nil }
+
+// setJail makes sys start the process chrooted to dir, unless it is
+// empty, and in a new mount namespace if mountNS is set.
+func setJail(sys *syscall.SysProcAttr, dir string, mountNS bool) {
+	sys.Chroot = dir
+	if mountNS {
+		sys.Unshareflags |= syscall.CLONE_NEWNS
+	}
+}
@@ -0,0 +1,21 @@
+//go:build unix && !linux
+
+package again
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+func chrootSupported() error { return nil }
+
+func mountNSSupported() error {
+	return fmt.Errorf("again: mount namespaces: %w", errors.ErrUnsupported)
+}
+
+// setJail makes sys start the process chrooted to dir, unless it is
+// empty. Mount namespaces are rejected by jailPaths.
+func setJail(sys *syscall.SysProcAttr, dir string, mountNS bool) {
+	sys.Chroot = dir
+}
@@ -0,0 +1,8 @@
+package again
+
+import "syscall"
+
+func chrootSupported() error  { return syscall.EWINDOWS }
+func mountNSSupported() error { return syscall.EWINDOWS }
+
+func setJail(sys *syscall.SysProcAttr, dir string, mountNS bool) {}
@@ -0,0 +1,36 @@
+package again
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// ListenLaunchd adopts the sockets launchd activated for the Sockets entry
+// name of the job's plist and registers them with ListenGroup under name, so
+// they are handed to later generations like any other service. Call it in
+// the first generation only; children get them from their parent. It needs
+// macOS and cgo.
+func (a *Again) ListenLaunchd(name string) error {
+	fds, err := launchdFds(name)
+	if err != nil {
+		return fmt.Errorf("again: launchd socket %q: %w", name, err)
+	}
+	var ls []net.Listener
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			for _, fd := range fds[i+1:] {
+				os.NewFile(uintptr(fd), name).Close()
+			}
+			return fmt.Errorf("again: launchd socket %q: %w", name, err)
+		}
+		ls = append(ls, l)
+	}
+	return a.ListenGroup(name, ls...)
+}
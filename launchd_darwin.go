@@ -0,0 +1,31 @@
+//go:build cgo
+
+package again
+
+/*
+#include <launch.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// launchdFds returns the descriptors launchd activated for the named socket.
+func launchdFds(name string) ([]int, error) {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	var fds *C.int
+	var n C.size_t
+	if e := C.launch_activate_socket(cname, &fds, &n); e != 0 {
+		return nil, syscall.Errno(e)
+	}
+	defer C.free(unsafe.Pointer(fds))
+	out := make([]int, 0, int(n))
+	for _, fd := range unsafe.Slice(fds, int(n)) {
+		out = append(out, int(fd))
+	}
+	return out, nil
+}
@@ -0,0 +1,11 @@
+//go:build !darwin || !cgo
+
+package again
+
+import "errors"
+
+// launchdFds fails, launch_activate_socket is only reachable on macOS
+// through cgo.
+func launchdFds(name string) ([]int, error) {
+	return nil, errors.New("launchd activation needs macOS and cgo")
+}
@@ -0,0 +1,87 @@
+package again
+
+import (
+	"net"
+	"time"
+)
+
+// ShedPolicy decides what happens to connections over the limit set with
+// WithMaxConns.
+type ShedPolicy int
+
+const (
+	// ShedReject closes connections over the limit right after accepting
+	// them.
+	ShedReject ShedPolicy = iota
+	// ShedQueue holds a connection over the limit until another one
+	// closes, and closes it if that takes longer than the queue wait.
+	// Later clients wait in the listen backlog meanwhile.
+	ShedQueue
+)
+
+// connLimit caps the open connections of a service.
+type connLimit struct {
+	max    int
+	policy ShedPolicy
+	wait   time.Duration
+}
+
+// WithMaxConns caps the open connections of the named service at max, which
+// protects a process during long drains as well as under load. Connections
+// over the limit are handled according to policy; wait is how long
+// ShedQueue holds one. Shed connections are counted in Stats.Rejected.
+func WithMaxConns(name string, max int, policy ShedPolicy, wait time.Duration) Option {
+	return optionFunc(func(a *Again) {
+		if a.limits == nil {
+			a.limits = make(map[string]connLimit)
+		}
+		a.limits[name] = connLimit{max: max, policy: policy, wait: wait}
+	})
+}
+
+// applyLimit sets the connection limit configured for s.
+func (a *Again) applyLimit(s *Service) {
+	if l, ok := a.limits[s.Name]; ok && s.conns != nil {
+		s.conns.mu.Lock()
+		s.conns.limit = l
+		s.conns.mu.Unlock()
+	}
+}
+
+// admit reports whether c may be served under the limit of cs, holding it
+// for ShedQueue. closed is closed when the listener is closed.
+func (cs *connSet) admit(c net.Conn, closed <-chan struct{}) bool {
+	cs.mu.Lock()
+	l := cs.limit
+	full := l.max > 0 && len(cs.conns) >= l.max
+	cs.mu.Unlock()
+	if !full {
+		return true
+	}
+	if l.policy == ShedQueue && l.wait > 0 {
+		t := time.NewTimer(l.wait)
+		defer t.Stop()
+		for full {
+			select {
+			case <-cs.freed:
+			case <-t.C:
+				cs.shed(c)
+				return false
+			case <-closed:
+				cs.shed(c)
+				return false
+			}
+			cs.mu.Lock()
+			full = len(cs.conns) >= l.max
+			cs.mu.Unlock()
+		}
+		return true
+	}
+	cs.shed(c)
+	return false
+}
+
+func (cs *connSet) shed(c net.Conn) {
+	cs.rejected.Add(1)
+	c.Close()
+}
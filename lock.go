@@ -0,0 +1,79 @@
+package again
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Locker is a cluster-wide lock supplied by the caller, e.g. an etcd or
+// Consul semaphore, that lets only a limited number of instances upgrade at
+// the same time.
+type Locker interface {
+	// Lock blocks until the lock is held or ctx is done.
+	Lock(ctx context.Context) error
+	Unlock(ctx context.Context) error
+}
+
+// WithUpgradeLock makes ForkExec, UpgradeServices and Pool.RollingRestart
+// hold l while upgrading. ForkExec and UpgradeServices release it when the
+// child reports that it is ready. Acquiring gives up after timeout, if
+// positive, and the upgrade fails.
+func WithUpgradeLock(l Locker, timeout time.Duration) Option {
+	return optionFunc(func(a *Again) {
+		a.locker = l
+		a.lockTimeout = timeout
+	})
+}
+
+// lockContext returns the context for a Locker call.
+func (a *Again) lockContext() (context.Context, context.CancelFunc) {
+	if a.lockTimeout > 0 {
+		return context.WithTimeout(context.Background(), a.lockTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// lockUpgrade acquires the upgrade lock, if any.
+func (a *Again) lockUpgrade() error {
+	if a.locker == nil {
+		return nil
+	}
+	ctx, cancel := a.lockContext()
+	defer cancel()
+	start := time.Now()
+	if err := a.locker.Lock(ctx); err != nil {
+		return fmt.Errorf("upgrade lock: %w", err)
+	}
+	wait := time.Since(start)
+	a.lc.mu.Lock()
+	a.lc.locked = true
+	a.lc.mu.Unlock()
+	a.emit(Event{Type: EventUpgradeLocked, Duration: wait})
+	if a.Hooks.OnUpgradeLocked != nil {
+		a.Hooks.OnUpgradeLocked(a, wait)
+	}
+	return nil
+}
+
+// unlockUpgrade releases the upgrade lock if it is held.
+func (a *Again) unlockUpgrade() {
+	a.lc.mu.Lock()
+	locked := a.lc.locked
+	a.lc.locked = false
+	a.lc.mu.Unlock()
+	if !locked {
+		return
+	}
+	ctx, cancel := a.lockContext()
+	defer cancel()
+	err := a.locker.Unlock(ctx)
+	if err != nil {
+		a.log(slog.LevelError, "again: upgrade unlock", "err", err)
+	}
+	a.emit(Event{Type: EventUpgradeUnlocked, Err: err})
+	if a.Hooks.OnUpgradeUnlocked != nil {
+		a.Hooks.OnUpgradeUnlocked(a)
+	}
+}
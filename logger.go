@@ -0,0 +1,81 @@
+package again
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// Logger receives the messages again logs. *slog.Logger implements it.
+type Logger interface {
+	Log(ctx context.Context, level slog.Level, msg string, args ...any)
+}
+
+// WithLogger makes again log through l instead of the log package. Every
+// message carries the generation of the process and its role, "parent"
+// once it started the next generation and "child" if it was handed its
+// listeners, and messages about a service its name, as slog attributes.
+func WithLogger(l Logger) Option {
+	return optionFunc(func(a *Again) {
+		a.logger = l
+	})
+}
+
+// WithLogHandler makes again log through a slog.Logger writing to h, see
+// WithLogger.
+func WithLogHandler(h slog.Handler) Option {
+	return WithLogger(slog.New(h))
+}
+
+// WithLogLevel drops messages below level, by default debug messages such
+// as the services a child inherited. It applies to the log package as well
+// as to a logger set with WithLogger.
+func WithLogLevel(level slog.Level) Option {
+	return optionFunc(func(a *Again) {
+		a.logLevel = level
+	})
+}
+
+// WithQuiet silences all messages of the instance. Errors are still
+// returned and reported as events.
+func WithQuiet() Option {
+	return optionFunc(func(a *Again) {
+		a.quiet = true
+	})
+}
+
+// log logs msg with the key-value pairs args and the lifecycle attributes,
+// or with the log package as "msg key=value ..." if there is no logger. a is
+// nil for the package level functions.
+func (a *Again) log(level slog.Level, msg string, args ...any) {
+	if a != nil && (a.quiet || level < a.logLevel) {
+		return
+	}
+	if a == nil || a.logger == nil {
+		var b strings.Builder
+		b.WriteString(msg)
+		for i := 0; i+1 < len(args); i += 2 {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		}
+		log.Print(b.String())
+		return
+	}
+	args = append(args, "generation", a.generation)
+	if role := a.role(); role != "" {
+		args = append(args, "role", role)
+	}
+	a.logger.Log(context.Background(), level, msg, args...)
+}
+
+// role returns the role of this process in an upgrade.
+func (a *Again) role() string {
+	switch {
+	case a.child.PID != 0:
+		return "parent"
+	case a.ppid != 0:
+		return "child"
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package again
+
+import "time"
+
+// minExpiryInterval and maxExpiryInterval bound how often connections are
+// checked for their age.
+const (
+	minExpiryInterval = 10 * time.Millisecond
+	maxExpiryInterval = time.Minute
+)
+
+// WithConnMaxAge caps the lifetime of tracked connections while serving:
+// a connection older than max plus a random share of jitter is closed and
+// reported with EventConnsExpired. Spreading the ages out means that most
+// connections are young when an upgrade comes and draining finishes
+// quickly, without all clients reconnecting at the same time. The check
+// runs while Wait does, every tenth of max but at least once a minute and
+// at most every 10ms.
+func WithConnMaxAge(max, jitter time.Duration) Option {
+	return optionFunc(func(a *Again) {
+		a.connMaxAge = max
+		a.connMaxAgeJitter = jitter
+	})
+}
+
+// startExpiry closes connections past their maximum age until the returned
+// function is called.
+func (a *Again) startExpiry() func() {
+	if a.connMaxAge <= 0 {
+		return func() {}
+	}
+	interval := a.connMaxAge / 10
+	if interval > maxExpiryInterval {
+		interval = maxExpiryInterval
+	} else if interval < minExpiryInterval {
+		interval = minExpiryInterval
+	}
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				if cut := a.closeExpired(now); len(cut) > 0 {
+					a.emit(Event{Type: EventConnsExpired, Conns: cut})
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func (a *Again) closeExpired(now time.Time) []string {
+	var cut []string
+	a.Range(func(s *Service) {
+		if s.conns == nil {
+			return
+		}
+		for _, c := range s.conns.list() {
+			max := a.connMaxAge + time.Duration(c.jitter*float64(a.connMaxAgeJitter))
+			if now.Sub(c.created) > max {
+				cut = append(cut, s.Name+" "+c.RemoteAddr().String())
+				s.conns.expired.Add(1)
+				c.Close()
+			}
+		}
+	})
+	return cut
+}
@@ -0,0 +1,22 @@
+package again_test
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/TykTechnologies/again"
+)
+
+func TestConnMaxAgeTiny(t *testing.T) {
+	ch := make(chan os.Signal, 1)
+	a := again.New(
+		again.WithSignalSource(ch),
+		again.WithConnMaxAge(time.Nanosecond, 0),
+	)
+	time.AfterFunc(50*time.Millisecond, func() { ch <- syscall.SIGTERM })
+	if r := again.WaitResult(&a); r.Signal != syscall.SIGTERM || r.Err != nil {
+		t.Fatalf("Wait returned %v: %v", r.Signal, r.Err)
+	}
+}
@@ -0,0 +1,50 @@
+package again
+
+import (
+	"encoding/json"
+	"log/slog"
+)
+
+// metaState is the state key metadata is handed over under.
+const metaState = "meta"
+
+// SetHandoffMeta attaches key=value to the handoff to the next generation,
+// e.g. a config hash, feature flags or a deploy ID. Like other handed over
+// state it travels through a pipe, not the environment, and should be
+// small. Setting a key to "" removes it.
+func (a *Again) SetHandoffMeta(key, value string) {
+	a.lc.mu.Lock()
+	first := a.lc.meta == nil
+	if first {
+		a.lc.meta = make(map[string]string)
+	}
+	if value == "" {
+		delete(a.lc.meta, key)
+	} else {
+		a.lc.meta[key] = value
+	}
+	a.lc.mu.Unlock()
+	if first {
+		a.addState(metaState, func() ([]byte, error) {
+			a.lc.mu.Lock()
+			defer a.lc.mu.Unlock()
+			return json.Marshal(a.lc.meta)
+		})
+	}
+}
+
+// HandoffMeta returns the metadata the parent attached with SetHandoffMeta,
+// or nil if there is none. It is not handed on to the next generation
+// unless set again.
+func (a *Again) HandoffMeta() map[string]string {
+	b, ok := a.inheritedState(metaState)
+	if !ok {
+		return nil
+	}
+	var meta map[string]string
+	if err := json.Unmarshal(b, &meta); err != nil {
+		a.log(slog.LevelError, "again: handoff metadata", "err", err)
+		return nil
+	}
+	return meta
+}
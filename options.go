@@ -0,0 +1,30 @@
+package again
+
+// Option configures an Again instance created with New.
+type Option interface {
+	apply(*Again)
+}
+
+type optionFunc func(*Again)
+
+func (fn optionFunc) apply(a *Again) { fn(a) }
+
+// apply makes Hooks usable as an Option so New(hooks) keeps working.
+func (h Hooks) apply(a *Again) { a.Hooks = h }
+
+// WithHookConcurrency limits how many hooks are executed at the same time when
+// a signal is received. Zero selects the default, negative values remove the
+// limit.
+func WithHookConcurrency(n int) Option {
+	return optionFunc(func(a *Again) {
+		a.hookConcurrency = n
+	})
+}
+
+// WithForkHook makes Wait call fn before it starts an upgrade, like the
+// forkHook argument of ListenFrom.
+func WithForkHook(fn func()) Option {
+	return optionFunc(func(a *Again) {
+		a.forkHook = fn
+	})
+}
@@ -0,0 +1,79 @@
+package again
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CloseAfter records that the named service must be closed after every
+// service in before. Close, CloseExcept and ShutdownContext honour these
+// constraints; services without constraints are closed in name order. An
+// error is returned if the constraint would create a cycle.
+func (a *Again) CloseAfter(name string, before ...string) error {
+	a.lc.mu.Lock()
+	defer a.lc.mu.Unlock()
+	if a.lc.after == nil {
+		a.lc.after = make(map[string][]string)
+	}
+	for _, b := range before {
+		if b == name || dependsOn(a.lc.after, b, name) {
+			return fmt.Errorf("again: closing %s after %s creates a cycle", name, b)
+		}
+		a.lc.after[name] = append(a.lc.after[name], b)
+	}
+	return nil
+}
+
+// dependsOn reports whether from has to be closed after to, directly or
+// transitively.
+func dependsOn(after map[string][]string, from, to string) bool {
+	for _, b := range after[from] {
+		if b == to || dependsOn(after, b, to) {
+			return true
+		}
+	}
+	return false
+}
+
+// closeOrder returns all services in the order they should be closed.
+func (a *Again) closeOrder() []*Service {
+	var all []*Service
+	a.Range(func(s *Service) {
+		all = append(all, s)
+	})
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	a.lc.mu.Lock()
+	after := a.lc.after
+	a.lc.mu.Unlock()
+	if len(after) == 0 {
+		return all
+	}
+	// Repeatedly take the first service whose predecessors are all done.
+	// Predecessors that are not registered are ignored.
+	registered := make(map[string]bool, len(all))
+	for _, s := range all {
+		registered[s.Name] = true
+	}
+	done := make(map[string]bool, len(all))
+	ordered := make([]*Service, 0, len(all))
+	for len(ordered) < len(all) {
+		for _, s := range all {
+			if done[s.Name] {
+				continue
+			}
+			ready := true
+			for _, b := range after[s.Name] {
+				if registered[b] && !done[b] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				done[s.Name] = true
+				ordered = append(ordered, s)
+				break
+			}
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,204 @@
+//go:build unix
+
+package again_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/TykTechnologies/again"
+)
+
+// fakeOS records the processes started instead of starting them, or fails
+// to start them with err. If passed is set it receives copies of the
+// descriptors after stdio of each process.
+type fakeOS struct {
+	started []*os.ProcAttr
+	err     error
+	passed  chan []int
+}
+
+func (o *fakeOS) Exec(argv0 string, argv, envv []string) error {
+	return syscall.ENOSYS
+}
+
+func (o *fakeOS) StartProcess(argv0 string, argv []string, attr *os.ProcAttr) (int, error) {
+	if o.err != nil {
+		return 0, o.err
+	}
+	o.started = append(o.started, attr)
+	if o.passed != nil {
+		var fds []int
+		for _, f := range attr.Files[3:] {
+			fd, err := syscall.Dup(int(f.Fd()))
+			if err != nil {
+				return 0, err
+			}
+			fds = append(fds, fd)
+		}
+		o.passed <- fds
+	}
+	return 4242, nil
+}
+
+func (o *fakeOS) Kill(pid int, sig syscall.Signal) error {
+	return nil
+}
+
+func (o *fakeOS) SetCloexec(fd uintptr, cloexec bool) error {
+	return nil
+}
+
+func (o *fakeOS) DupCloexec(fd uintptr) (uintptr, error) {
+	nfd, err := syscall.Dup(int(fd))
+	return uintptr(nfd), err
+}
+
+func TestForkExecOS(t *testing.T) {
+	sys := &fakeOS{}
+	a := again.New(again.WithOS(sys), again.WithEnvPrefix("FAKE"))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Listen("web", l); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	var spawned again.ChildInfo
+	a.Hooks.OnChildSpawned = func(_ *again.Again, c again.ChildInfo) { spawned = c }
+	if err := again.ForkExec(&a); err != nil {
+		t.Fatal(err)
+	}
+	if len(sys.started) != 1 {
+		t.Fatalf("started %d processes, want 1", len(sys.started))
+	}
+	attr := sys.started[0]
+	if len(attr.Files) != 4 {
+		t.Errorf("child gets %d files, want stdio and the listener", len(attr.Files))
+	}
+	for _, kv := range []string{
+		a.EnvName("FD") + "=3",
+		a.EnvName("SERVICE_NAME") + "=web",
+		a.EnvName("PPID") + "=" + fmt.Sprint(os.Getpid()),
+		a.EnvName("GENERATION") + "=1",
+	} {
+		if !slices.Contains(attr.Env, kv) {
+			t.Errorf("child environment lacks %s", kv)
+		}
+	}
+	if spawned.PID != 4242 || spawned.Generation != 1 {
+		t.Errorf("OnChildSpawned got %+v", spawned)
+	}
+}
+
+func TestForkExecOSFailure(t *testing.T) {
+	sys := &fakeOS{err: syscall.EAGAIN}
+	a := again.New(again.WithOS(sys))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Listen("web", l); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if err := again.ForkExec(&a); !errors.Is(err, syscall.EAGAIN) {
+		t.Fatalf("ForkExec returned %v, want %v", err, syscall.EAGAIN)
+	}
+	if h := a.History(); len(h) != 1 || h[0].Outcome != again.UpgradeFailed {
+		t.Errorf("history %+v, want one failed upgrade", h)
+	}
+	// The failed attempt must not hold on to the upgrade.
+	sys.err = nil
+	if err := again.ForkExec(&a); err != nil {
+		t.Fatalf("ForkExec after a failure: %v", err)
+	}
+}
+
+func TestWaitResultChild(t *testing.T) {
+	ch := make(chan os.Signal, 2)
+	a := again.New(again.WithOS(&fakeOS{}), again.WithSignalSource(ch))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Listen("web", l); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	ch <- again.SIGUSR2
+	ch <- syscall.SIGINT
+	r := again.WaitResult(&a)
+	if r.Signal != syscall.SIGINT || r.Child.PID != 4242 {
+		t.Fatalf("Wait returned %v with child %+v, want SIGINT with child 4242", r.Signal, r.Child)
+	}
+}
+
+// TestPartialUpgradeHealthCheck checks that the child of UpgradeServices is
+// health checked and that a check ignoring its deadline doesn't hold up Wait.
+func TestPartialUpgradeHealthCheck(t *testing.T) {
+	ch := make(chan os.Signal, 1)
+	var checked atomic.Bool
+	release := make(chan struct{})
+	defer close(release)
+	check := func(ctx context.Context, child again.ChildInfo) error {
+		checked.Store(true)
+		<-release
+		return nil
+	}
+	aborted := make(chan error, 1)
+	a := again.New(
+		again.WithOS(&fakeOS{}),
+		again.WithSignalSource(ch),
+		again.WithHealthCheck(check, 50*time.Millisecond),
+		again.WithEventHandler(func(e again.Event) {
+			if e.Type == again.EventUpgradeAborted {
+				aborted <- e.Err
+			}
+		}),
+	)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Listen("web", l); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if err := a.UpgradeServices("web"); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := again.Wait(&a)
+		done <- err
+	}()
+	ch <- syscall.SIGQUIT
+	select {
+	case err := <-aborted:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("upgrade aborted with %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("partial upgrade was not rejected")
+	}
+	if !checked.Load() {
+		t.Error("child of UpgradeServices was not health checked")
+	}
+	if a.Get("web") == nil {
+		t.Error("web was handed over to the rejected child")
+	}
+	a.Stop()
+	if err := <-done; err != again.ErrStopped {
+		t.Fatalf("Wait returned %v, want %v", err, again.ErrStopped)
+	}
+}
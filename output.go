@@ -0,0 +1,93 @@
+package again
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// pipedEnv tells a child that its stdout and stderr are pipes to the parent.
+const pipedEnv = "PIPED"
+
+// ChildOutputFunc receives a line written by a child to stdout (stream 1) or
+// stderr (stream 2).
+type ChildOutputFunc func(child ChildInfo, stream int, line string)
+
+// WithChildOutput pipes the stdout and stderr of spawned processes through
+// the parent and hands every line to fn instead of letting the child write to
+// the parent's stdio directly.
+//
+// Output can only be relayed while the parent is alive. Once it has exited
+// writes fail; the child ignores SIGPIPE so this doesn't kill it, but the
+// output is lost. It is therefore most useful with a Pool, whose master
+// outlives its workers.
+func WithChildOutput(fn ChildOutputFunc) Option {
+	return optionFunc(func(a *Again) {
+		a.childOutput = fn
+	})
+}
+
+// PrefixOutput is a ChildOutputFunc that writes lines to the parent's stdout
+// or stderr prefixed with the generation and PID of the child.
+func PrefixOutput(child ChildInfo, stream int, line string) {
+	w := os.Stdout
+	if stream == 2 {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, "[gen %d pid %d] %s\n", child.Generation, child.PID, line)
+}
+
+// outputPipes replaces stdout and stderr in files with pipes if child output
+// is captured. The returned function starts relaying once the PID is known;
+// it must be called exactly once, also when starting the process failed.
+func (a *Again) outputPipes(files []*os.File, env map[string]string) (func(ChildInfo), error) {
+	if a.childOutput == nil {
+		return func(ChildInfo) {}, nil
+	}
+	var readers []*os.File
+	var writers []*os.File
+	for stream := 1; stream <= 2; stream++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			for _, f := range append(readers, writers...) {
+				f.Close()
+			}
+			return nil, err
+		}
+		readers = append(readers, r)
+		writers = append(writers, w)
+		files[stream] = w
+	}
+	env[a.envName(pipedEnv)] = "1"
+	return func(child ChildInfo) {
+		for _, w := range writers {
+			w.Close()
+		}
+		for i, r := range readers {
+			if child.PID == 0 {
+				r.Close()
+				continue
+			}
+			go a.relay(child, i+1, r)
+		}
+	}, nil
+}
+
+func (a *Again) relay(child ChildInfo, stream int, r io.ReadCloser) {
+	defer r.Close()
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		a.childOutput(child, stream, sc.Text())
+	}
+}
+
+// ignoreSIGPIPE keeps a child whose output is piped through its parent alive
+// after the parent has exited.
+func (a *Again) ignoreSIGPIPE() {
+	if a.getenv(pipedEnv) == "1" {
+		signal.Ignore(syscall.SIGPIPE)
+	}
+}
@@ -0,0 +1,103 @@
+package again
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// packetListener stores a net.PacketConn as the Listener of a Service, so
+// datagram sockets are handed over, closed and ordered like any other
+// service. Accept blocks until it is closed.
+type packetListener struct {
+	net.PacketConn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newPacketListener(pc net.PacketConn) *packetListener {
+	return &packetListener{PacketConn: pc, closed: make(chan struct{})}
+}
+
+func (l *packetListener) Accept() (net.Conn, error) {
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *packetListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return l.PacketConn.Close()
+}
+
+func (l *packetListener) Addr() net.Addr {
+	return l.LocalAddr()
+}
+
+func (l *packetListener) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := l.PacketConn.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedListener, l.PacketConn)
+	}
+	return sc.SyscallConn()
+}
+
+// isPacketNetwork reports whether network is datagram based.
+func isPacketNetwork(network string) bool {
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+		return true
+	}
+	return false
+}
+
+// ListenPacket registers the UDP or unixgram socket pc as the service name,
+// e.g. the socket of a QUIC server. It is handed to the next generation like
+// a listener. QUIC connection state lives in memory and can't follow it:
+// close the QUIC transport from the OnSIGQUIT hook so clients get
+// CONNECTION_CLOSE and reconnect to the new process instead of running into
+// stateless resets.
+func (a *Again) ListenPacket(name string, pc net.PacketConn, opts ...RegisterOption) error {
+	l := newPacketListener(pc)
+	fd, err := listenerFd(l)
+	if err != nil {
+		return err
+	}
+	s := &Service{
+		Name:       name,
+		FdName:     ListerName(l),
+		Listener:   l,
+		Descriptor: fd,
+	}
+	return a.add(s, opts)
+}
+
+// PacketConn returns the socket of a service registered with ListenPacket,
+// or nil for stream services.
+func (s *Service) PacketConn() net.PacketConn {
+	if l, ok := s.Listener.(*packetListener); ok {
+		return l.PacketConn
+	}
+	return nil
+}
+
+// GetPacketConn returns the socket of the named packet service or nil.
+func (a Again) GetPacketConn(key string) net.PacketConn {
+	if s := a.Get(key); s != nil {
+		return s.PacketConn()
+	}
+	return nil
+}
+
+// listen binds network and addr with lc, wrapping datagram sockets.
+func listen(lc *net.ListenConfig, network, addr string) (net.Listener, error) {
+	if isPacketNetwork(network) {
+		pc, err := lc.ListenPacket(context.Background(), network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return newPacketListener(pc), nil
+	}
+	return lc.Listen(context.Background(), network, addr)
+}
@@ -0,0 +1,69 @@
+package again
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// UpgradeServices hands only the named services to a new process. The
+// current process keeps serving all other services; the named ones are
+// closed here once the new process reports it is ready by killing its parent
+// with Kill and passed the check of WithHealthCheck, and Wait carries on
+// instead of returning. At least one service must be named.
+func (a *Again) UpgradeServices(names ...string) error {
+	if len(names) == 0 {
+		return errors.New("again: UpgradeServices needs at least one service, use ForkExec for all")
+	}
+	for _, name := range names {
+		if a.Get(name) == nil {
+			return fmt.Errorf("%w: %q", ErrUnknownService, name)
+		}
+	}
+	a.lc.mu.Lock()
+	if a.lc.partial != nil {
+		a.lc.mu.Unlock()
+		return ErrUpgradeInProgress
+	}
+	a.lc.partial = names
+	a.lc.mu.Unlock()
+	a.setState(Upgrading)
+	if err := forkExec(a, names, nil); err != nil {
+		a.lc.mu.Lock()
+		a.lc.partial = nil
+		a.lc.mu.Unlock()
+		a.setState(Serving)
+		return err
+	}
+	return nil
+}
+
+// partialPending reports whether UpgradeServices started a new process that
+// hasn't reported ready yet.
+func (a *Again) partialPending() bool {
+	a.lc.mu.Lock()
+	defer a.lc.mu.Unlock()
+	return a.lc.partial != nil
+}
+
+// finishPartial closes the services handed over by UpgradeServices. It
+// returns false if no partial upgrade is pending.
+func (a *Again) finishPartial() bool {
+	a.lc.mu.Lock()
+	names := a.lc.partial
+	a.lc.partial = nil
+	a.lc.mu.Unlock()
+	if names == nil {
+		return false
+	}
+	if a.Hooks.OnChildReady != nil {
+		a.Hooks.OnChildReady(a, a.child)
+	}
+	for _, name := range names {
+		if err := a.CloseService(name); err != nil {
+			a.log(slog.LevelError, "again: closing service", "service", name, "err", err)
+		}
+	}
+	a.setState(Serving)
+	return true
+}
@@ -0,0 +1,17 @@
+package again_test
+
+import (
+	"testing"
+
+	"github.com/TykTechnologies/again"
+)
+
+func TestUpgradeServicesNone(t *testing.T) {
+	a := again.New()
+	if err := a.UpgradeServices(); err == nil {
+		t.Fatal("UpgradeServices without services succeeded")
+	}
+	if s := a.State(); s == again.Upgrading {
+		t.Errorf("state %v after a refused partial upgrade", s)
+	}
+}
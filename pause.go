@@ -0,0 +1,144 @@
+package again
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// pauseGate holds up Accept while a service is paused.
+type pauseGate struct {
+	mu sync.Mutex
+	// resumed is non-nil while paused and closed on resume.
+	resumed chan struct{}
+	// closed is closed when the listener is closed.
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPauseGate() *pauseGate {
+	return &pauseGate{closed: make(chan struct{})}
+}
+
+// wait blocks while paused. It returns false if the listener was closed.
+func (g *pauseGate) wait() bool {
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+	if resumed == nil {
+		return true
+	}
+	select {
+	case <-resumed:
+		return true
+	case <-g.closed:
+		return false
+	}
+}
+
+func (g *pauseGate) paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resumed != nil
+}
+
+// deadliner is implemented by listeners whose blocked Accept can be
+// interrupted.
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// pause stops accepting on l. A blocked Accept is interrupted if the
+// listener supports deadlines, otherwise it returns one more connection.
+func (l *trackingListener) pause() {
+	g := l.gate
+	g.mu.Lock()
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+	g.mu.Unlock()
+	if d, ok := l.Listener.(deadliner); ok {
+		d.SetDeadline(time.Now())
+	}
+}
+
+// resume accepts on l again.
+func (l *trackingListener) resume() {
+	g := l.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		return
+	}
+	if d, ok := l.Listener.(deadliner); ok {
+		d.SetDeadline(time.Time{})
+	}
+	close(g.resumed)
+	g.resumed = nil
+}
+
+func (l *trackingListener) Close() error {
+	l.gate.closeOnce.Do(func() { close(l.gate.closed) })
+	return l.Listener.Close()
+}
+
+// accept accepts the next connection, waiting while paused. Clients
+// connecting meanwhile wait in the listen backlog.
+func (l *trackingListener) accept() (net.Conn, error) {
+	for {
+		if !l.gate.wait() {
+			return nil, net.ErrClosed
+		}
+		c, err := l.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && l.gate.paused() {
+				continue
+			}
+			return nil, err
+		}
+		if !l.conns.admit(c, l.gate.closed) {
+			continue
+		}
+		return c, nil
+	}
+}
+
+// Pause stops accepting connections on s without closing its listener, so
+// it keeps being handed to the next generation and clients wait in the
+// listen backlog. Open connections are not affected. Packet and raw
+// services can't be paused.
+func (s *Service) Pause() error {
+	t, ok := s.Listener.(*trackingListener)
+	if !ok {
+		return &ServiceError{Service: s.Name, Err: ErrUnsupportedListener}
+	}
+	t.pause()
+	return nil
+}
+
+// Resume accepts connections on s again after Pause.
+func (s *Service) Resume() {
+	if t, ok := s.Listener.(*trackingListener); ok {
+		t.resume()
+	}
+}
+
+// Paused reports whether s is paused.
+func (s *Service) Paused() bool {
+	t, ok := s.Listener.(*trackingListener)
+	return ok && t.gate.paused()
+}
+
+// PauseAll pauses every service that can be paused.
+func (a *Again) PauseAll() {
+	a.Range(func(s *Service) {
+		s.Pause()
+	})
+}
+
+// ResumeAll resumes every paused service.
+func (a *Again) ResumeAll() {
+	a.Range(func(s *Service) {
+		s.Resume()
+	})
+}
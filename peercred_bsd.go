@@ -0,0 +1,32 @@
+//go:build darwin || freebsd
+
+package again
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCred returns the user and the process at the other end of uc. The
+// process is 0 if the kernel doesn't tell.
+func peerCred(uc *net.UnixConn) (uid, pid int, err error) {
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return -1, 0, err
+	}
+	var cred *unix.Xucred
+	var cerr error
+	if err := rc.Control(func(fd uintptr) {
+		cred, cerr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+		if cerr == nil {
+			pid = xucredPid(int(fd), cred)
+		}
+	}); err != nil {
+		return -1, 0, err
+	}
+	if cerr != nil {
+		return -1, 0, cerr
+	}
+	return int(cred.Uid), pid, nil
+}
@@ -0,0 +1,12 @@
+package again
+
+import "golang.org/x/sys/unix"
+
+// xucredPid returns the process at the other end of the socket fd, or 0.
+func xucredPid(fd int, cred *unix.Xucred) int {
+	pid, err := unix.GetsockoptInt(fd, unix.SOL_LOCAL, unix.LOCAL_PEERPID)
+	if err != nil {
+		return 0
+	}
+	return pid
+}
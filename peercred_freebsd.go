@@ -0,0 +1,15 @@
+package again
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// xucredPid returns the process at the other end of the socket cred was
+// read from, or 0. unix.Xucred hides cr_pid, which FreeBSD 13 and later
+// keep in the union that ends the struct.
+func xucredPid(fd int, cred *unix.Xucred) int {
+	const pidOffset = unsafe.Sizeof(unix.Xucred{}) - unsafe.Sizeof(uintptr(0))
+	return int(*(*int32)(unsafe.Add(unsafe.Pointer(cred), pidOffset)))
+}
@@ -0,0 +1,26 @@
+package again
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCred returns the user and the process at the other end of uc.
+func peerCred(uc *net.UnixConn) (uid, pid int, err error) {
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return -1, 0, err
+	}
+	var cred *unix.Ucred
+	var cerr error
+	if err := rc.Control(func(fd uintptr) {
+		cred, cerr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return -1, 0, err
+	}
+	if cerr != nil {
+		return -1, 0, cerr
+	}
+	return int(cred.Uid), int(cred.Pid), nil
+}
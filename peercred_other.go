@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd
+
+package again
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCred is not implemented here.
+func peerCred(uc *net.UnixConn) (uid, pid int, err error) {
+	return -1, 0, syscall.ENOPROTOOPT
+}
@@ -0,0 +1,28 @@
+package again
+
+import "syscall"
+
+// WithControlPipe makes Wait take signals from the Windows named pipe
+// \\.\pipe\name, which SignalPipe writes to. Windows can't send SIGTERM,
+// SIGHUP and the like to another process, so this is how tooling drains,
+// reloads or reopens the logs of an instance there; the signal is handled
+// as if the process received it. The pipe has the default security of named
+// pipes, only the user running this process, administrators and SYSTEM may
+// write to it, and rejects remote clients. Elsewhere it is not supported,
+// send signals instead.
+func WithControlPipe(name string) Option {
+	return optionFunc(func(a *Again) {
+		a.controlPipe = name
+	})
+}
+
+// SignalPipe makes the instance serving the named pipe name, see
+// WithControlPipe, act as if it received sig.
+func SignalPipe(name string, sig syscall.Signal) error {
+	return signalPipe(pipePath(name), sig)
+}
+
+// pipePath returns the path of the named pipe name.
+func pipePath(name string) string {
+	return `\\.\pipe\` + name
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package again
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"syscall"
+)
+
+// startPipe reports the control pipe as unsupported, see WithControlPipe.
+func (a *Again) startPipe() func() {
+	if a.controlPipe != "" {
+		a.log(slog.LevelError, "again: control pipe", "err", errors.ErrUnsupported)
+	}
+	return func() {}
+}
+
+func signalPipe(path string, sig syscall.Signal) error {
+	return fmt.Errorf("again: control pipe: %w", errors.ErrUnsupported)
+}
@@ -0,0 +1,105 @@
+package again
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// pipeBuffer is the input buffer size of the control pipe; a signal number
+// is all a client sends.
+const pipeBuffer = 64
+
+// pipeBusyTimeout bounds how long SignalPipe waits for the control pipe
+// while it serves another client.
+const pipeBusyTimeout = time.Second
+
+// startPipe serves the control pipe until the returned function is called.
+func (a *Again) startPipe() func() {
+	if a.controlPipe == "" {
+		return func() {}
+	}
+	path := pipePath(a.controlPipe)
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		a.log(slog.LevelError, "again: control pipe", "err", err)
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			// Serving one client at a time, each instance is the first:
+			// that fails if some other process squats the name.
+			h, err := windows.CreateNamedPipe(name, windows.PIPE_ACCESS_INBOUND|windows.FILE_FLAG_FIRST_PIPE_INSTANCE,
+				windows.PIPE_TYPE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+				windows.PIPE_UNLIMITED_INSTANCES, 0, pipeBuffer, 0, nil)
+			if err != nil {
+				a.log(slog.LevelError, "again: control pipe", "err", err)
+				return
+			}
+			if err := windows.ConnectNamedPipe(h, nil); err != nil && err != windows.ERROR_PIPE_CONNECTED {
+				windows.CloseHandle(h)
+				a.log(slog.LevelError, "again: control pipe", "err", err)
+				return
+			}
+			select {
+			case <-done:
+				windows.CloseHandle(h)
+				return
+			default:
+			}
+			a.readPipe(os.NewFile(uintptr(h), path))
+		}
+	}()
+	return func() {
+		close(done)
+		// Connecting is the only way to wake up ConnectNamedPipe.
+		if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+			f.Close()
+		}
+	}
+}
+
+// readPipe reads a signal number from a client of the control pipe and
+// delivers it to Wait.
+func (a *Again) readPipe(f *os.File) {
+	defer f.Close()
+	line, err := bufio.NewReaderSize(f, pipeBuffer).ReadString('\n')
+	if err != nil {
+		a.log(slog.LevelWarn, "again: control pipe", "err", err)
+		return
+	}
+	var n int
+	if _, err := fmt.Sscan(line, &n); err != nil || n <= 0 {
+		a.log(slog.LevelWarn, "again: control pipe: bad signal", "signal", line)
+		return
+	}
+	a.deliver(syscall.Signal(n), "pipe")
+}
+
+// signalPipe writes sig to the control pipe at path, waiting a moment if
+// it is busy with another client.
+func signalPipe(path string, sig syscall.Signal) error {
+	deadline := time.Now().Add(pipeBusyTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if errors.Is(err, windows.ERROR_PIPE_BUSY) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("again: control pipe: %w", err)
+		}
+		_, err = fmt.Fprintf(f, "%d\n", int(sig))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+}
@@ -0,0 +1,364 @@
+package again
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// workerEnv carries the slot number of a pool worker.
+const workerEnv = "WORKER"
+
+// WorkerID returns the slot of this process in a Pool and whether it is a
+// pool worker at all.
+func WorkerID() (int, bool) {
+	return workerID(nil)
+}
+
+// WorkerID is like the package level WorkerID but honours WithEnvPrefix.
+func (a *Again) WorkerID() (int, bool) {
+	return workerID(a)
+}
+
+func workerID(a *Again) (int, bool) {
+	id, err := strconv.Atoi(a.getenv(workerEnv))
+	return id, err == nil
+}
+
+// Pool runs the program as a master holding the listeners and a fixed number
+// of worker processes sharing them, nginx style. Workers are started by
+// re-executing the binary with the listeners passed like ForkExec does; they
+// call ListenFrom and serve. Crashed workers are restarted.
+//
+// When a Pool exists, Wait in the master answers SIGUSR2 with a rolling
+// restart of the workers instead of forking a new master, and stops the
+// workers with the received signal, or SIGTERM after Stop, before returning.
+type Pool struct {
+	// HealthCheck, if set, is called with a new worker during a rolling
+	// restart and is retried until it succeeds or HealthTimeout passes.
+	// Without it a worker counts as healthy if it is still running after
+	// HealthTimeout.
+	HealthCheck func(pid, slot int) error
+	// HealthTimeout bounds the health check of each new worker. It defaults
+	// to DefaultHealthTimeout.
+	HealthTimeout time.Duration
+
+	a    *Again
+	size int
+
+	mu       sync.Mutex
+	workers  []*worker
+	stopping bool
+	// exe is the binary the workers run.
+	exe *image
+}
+
+type worker struct {
+	slot int
+	pid  int
+	done chan struct{}
+	err  error
+}
+
+// restartDelay is the pause before a crashed worker is restarted.
+const restartDelay = time.Second
+
+// DefaultHealthTimeout is the HealthTimeout used when none is set, and the
+// timeout of WithHealthCheck if it is not positive.
+const DefaultHealthTimeout = 5 * time.Second
+
+// healthRetryInterval is the pause between failed health checks.
+const healthRetryInterval = 100 * time.Millisecond
+
+// NewPool returns a pool of n workers serving the services of a.
+func NewPool(a *Again, n int) *Pool {
+	p := &Pool{a: a, size: n, workers: make([]*worker, n)}
+	a.lc.mu.Lock()
+	a.lc.pool = p
+	a.lc.mu.Unlock()
+	return p
+}
+
+// Start starts all workers.
+func (p *Pool) Start() error {
+	exe, err := p.openExe()
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.exe.close()
+	p.exe = exe
+	p.mu.Unlock()
+	for slot := 0; slot < p.size; slot++ {
+		if _, err := p.start(slot, exe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openExe opens the binary to upgrade to.
+func (p *Pool) openExe() (*image, error) {
+	argv0, err := p.a.binary()
+	if err != nil {
+		return nil, err
+	}
+	return openImage(argv0)
+}
+
+// Workers returns the PIDs of the running workers by slot; 0 means the slot
+// is empty.
+func (p *Pool) Workers() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pids := make([]int, len(p.workers))
+	for i, w := range p.workers {
+		if w != nil {
+			pids[i] = w.pid
+		}
+	}
+	return pids
+}
+
+// pool returns the Pool of a, if any.
+func (a *Again) pool() *Pool {
+	a.lc.mu.Lock()
+	defer a.lc.mu.Unlock()
+	return a.lc.pool
+}
+
+// start spawns the worker for slot from exe, replacing whatever was recorded
+// there.
+func (p *Pool) start(slot int, exe *image) (*worker, error) {
+	pid, err := p.a.spawnImage(exe, nil, map[string]string{
+		p.a.envName(workerEnv): strconv.Itoa(slot),
+		// Workers are not upgrades, Kill in a worker must not hit us.
+		p.a.envName("SIGNAL"): "0",
+	})
+	if err != nil {
+		return nil, err
+	}
+	w := &worker{slot: slot, pid: pid, done: make(chan struct{})}
+	p.mu.Lock()
+	p.workers[slot] = w
+	p.mu.Unlock()
+	p.a.emit(Event{Type: EventWorkerStarted, PID: pid, Worker: slot})
+	go p.watch(w)
+	return w, nil
+}
+
+// watch reaps w and restarts its slot unless the pool is stopping or the
+// worker was replaced.
+func (p *Pool) watch(w *worker) {
+	ws, err := waitPid(w.pid)
+	if err != nil {
+		w.err = err
+	} else if !ws.Exited() || ws.ExitStatus() != 0 {
+		w.err = fmt.Errorf("%w: worker %d: %v", ErrChildFailed, w.pid, exitReason(ws))
+	}
+	close(w.done)
+	p.a.emit(Event{Type: EventWorkerExited, PID: w.pid, Worker: w.slot, Err: w.err})
+	p.mu.Lock()
+	restart := !p.stopping && p.workers[w.slot] == w
+	p.mu.Unlock()
+	if !restart {
+		return
+	}
+	p.a.log(slog.LevelWarn, "worker exited, restarting", "pid", w.pid, "worker", w.slot, "err", w.err)
+	time.Sleep(restartDelay)
+	p.mu.Lock()
+	restart = !p.stopping && p.workers[w.slot] == w
+	exe := p.exe
+	p.mu.Unlock()
+	if restart {
+		if _, err := p.start(w.slot, exe); err != nil {
+			p.a.log(slog.LevelError, "restarting worker", "worker", w.slot, "err", err)
+		}
+	}
+}
+
+func exitReason(ws syscall.WaitStatus) string {
+	if ws.Signaled() {
+		return "signal: " + ws.Signal().String()
+	}
+	return "exit status " + strconv.Itoa(ws.ExitStatus())
+}
+
+// RollingRestart replaces the workers one at a time: a new worker is started
+// in the slot and health checked, then the old one is sent SIGQUIT and
+// waited for. If a new worker fails its health check it is killed, the old
+// worker keeps its slot and the restart is aborted with
+// EventUpgradeAborted. The slots replaced before are then rolled back the
+// same way to the binary the pool ran before. The pool keeps that binary
+// open, so on Linux this works even after a deploy replaced it on disk;
+// elsewhere it has to still be at its path. Rolled back slots are reported
+// with EventWorkerRolledBack, slots that keep the new binary with
+// EventRollbackFailed. Progress is reported with EventWorkerUpgraded.
+func (p *Pool) RollingRestart() error {
+	if err := p.a.lockUpgrade(); err != nil {
+		p.a.emit(Event{Type: EventUpgradeAborted, Err: err})
+		return err
+	}
+	defer p.a.unlockUpgrade()
+	next, err := p.openExe()
+	if err != nil {
+		p.a.emit(Event{Type: EventUpgradeAborted, Err: err})
+		return err
+	}
+	p.mu.Lock()
+	prev := p.exe
+	p.mu.Unlock()
+	for slot := 0; slot < p.size; slot++ {
+		w, err := p.replace(slot, next)
+		if err != nil {
+			p.a.emit(Event{Type: EventUpgradeAborted, PID: w.pid, Worker: slot, Err: err})
+			p.rollback(slot, prev)
+			next.close()
+			return err
+		}
+		p.a.emit(Event{Type: EventWorkerUpgraded, PID: w.pid, Worker: slot})
+	}
+	p.mu.Lock()
+	p.exe = next
+	p.mu.Unlock()
+	prev.close()
+	return nil
+}
+
+// replace starts a worker from exe in slot and, once it passed the health
+// check, stops the one it replaces. A new worker failing the check is killed
+// and the old one keeps the slot. The new worker is returned either way, if
+// it was started.
+func (p *Pool) replace(slot int, exe *image) (*worker, error) {
+	p.mu.Lock()
+	old := p.workers[slot]
+	p.mu.Unlock()
+	w, err := p.start(slot, exe)
+	if err != nil {
+		return &worker{slot: slot}, err
+	}
+	if err := p.check(w); err != nil {
+		// An old worker that died meanwhile was not restarted by watch
+		// since its slot was taken; empty the slot and restart it here.
+		dead := false
+		p.mu.Lock()
+		p.workers[slot] = old
+		if old != nil {
+			select {
+			case <-old.done:
+				dead = true
+				p.workers[slot] = nil
+			default:
+			}
+		}
+		restart := dead && !p.stopping
+		exe := p.exe
+		p.mu.Unlock()
+		p.a.sys.Kill(w.pid, syscall.SIGKILL)
+		<-w.done
+		if restart {
+			if _, err := p.start(slot, exe); err != nil {
+				p.a.log(slog.LevelError, "restarting worker", "worker", slot, "err", err)
+			}
+		}
+		return w, fmt.Errorf("%w: worker %d: %w", ErrChildFailed, w.pid, err)
+	}
+	if old != nil {
+		p.a.sys.Kill(old.pid, syscall.SIGQUIT)
+		<-old.done
+	}
+	return w, nil
+}
+
+// rollback puts workers of prev back into the slots below n after a failed
+// rolling restart.
+func (p *Pool) rollback(n int, prev *image) {
+	for slot := 0; slot < n; slot++ {
+		if prev == nil {
+			err := errors.New("again: no previous binary to roll back to")
+			p.a.emit(Event{Type: EventRollbackFailed, Worker: slot, Err: err})
+			continue
+		}
+		w, err := p.replace(slot, prev)
+		if err != nil {
+			p.a.log(slog.LevelError, "rolling back worker", "worker", slot, "err", err)
+			p.a.emit(Event{Type: EventRollbackFailed, PID: w.pid, Worker: slot, Err: err})
+			continue
+		}
+		p.a.emit(Event{Type: EventWorkerRolledBack, PID: w.pid, Worker: slot})
+	}
+}
+
+// check runs the health check of a new worker.
+func (p *Pool) check(w *worker) error {
+	timeout := p.HealthTimeout
+	if timeout <= 0 {
+		timeout = DefaultHealthTimeout
+	}
+	deadline := time.After(timeout)
+	if p.HealthCheck == nil {
+		select {
+		case <-w.done:
+			return errors.New("exited during health check")
+		case <-deadline:
+			return nil
+		}
+	}
+	for {
+		err := p.HealthCheck(w.pid, w.slot)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-w.done:
+			return errors.New("exited during health check")
+		case <-deadline:
+			return err
+		case <-time.After(healthRetryInterval):
+		}
+	}
+}
+
+// Signal sends sig to all workers.
+func (p *Pool) Signal(sig syscall.Signal) error {
+	var errs []error
+	for _, pid := range p.Workers() {
+		if pid != 0 {
+			if err := p.a.sys.Kill(pid, sig); err != nil && err != syscall.ESRCH {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Stop sends sig to all workers and waits for them to exit. Workers are not
+// restarted afterwards.
+func (p *Pool) Stop(sig syscall.Signal) error {
+	p.mu.Lock()
+	p.stopping = true
+	workers := append([]*worker(nil), p.workers...)
+	p.mu.Unlock()
+	var errs []error
+	for _, w := range workers {
+		if w != nil {
+			if err := p.a.sys.Kill(w.pid, sig); err != nil && err != syscall.ESRCH {
+				errs = append(errs, err)
+			}
+		}
+	}
+	for _, w := range workers {
+		if w != nil {
+			<-w.done
+		}
+	}
+	p.mu.Lock()
+	p.exe.close()
+	p.exe = nil
+	p.mu.Unlock()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,97 @@
+package again_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/TykTechnologies/again"
+)
+
+// copyExe copies the test binary to path.
+func copyExe(t *testing.T, path string) {
+	t.Helper()
+	src, err := os.Open("/proc/self/exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	tmp := path + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		t.Fatal(err)
+	}
+	if err := dst.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRollingRestartRollback checks that a failed rolling restart puts the
+// slots it replaced back on the previous binary, also after a deploy
+// replaced it on disk.
+func TestRollingRestartRollback(t *testing.T) {
+	exe := filepath.Join(t.TempDir(), "app")
+	copyExe(t, exe)
+	prev, err := os.Stat(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ev events
+	a := again.New(
+		again.WithSignalSource(make(chan os.Signal)),
+		again.WithExecutable(exe),
+		again.WithEventHandler(ev.add),
+	)
+	p := again.NewPool(&a, 2)
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop(syscall.SIGTERM)
+	started(t, p)
+
+	// Deploy a new binary.
+	copyExe(t, exe)
+	p.HealthTimeout = 200 * time.Millisecond
+	p.HealthCheck = unhealthy(1)
+	if err := p.RollingRestart(); !errors.Is(err, again.ErrChildFailed) {
+		t.Fatalf("rolling restart returned %v, want %v", err, again.ErrChildFailed)
+	}
+	if len(ev.find(again.EventWorkerUpgraded, 0)) != 1 {
+		t.Error("slot 0 was not upgraded first")
+	}
+	if len(ev.find(again.EventUpgradeAborted, 1)) != 1 {
+		t.Error("no EventUpgradeAborted for slot 1")
+	}
+	rolled := ev.find(again.EventWorkerRolledBack, 0)
+	if len(rolled) != 1 {
+		t.Fatalf("got %+v, want one EventWorkerRolledBack for slot 0", rolled)
+	}
+	if failed := ev.find(again.EventRollbackFailed, 0); len(failed) != 0 {
+		t.Errorf("rollback failed: %v", failed[0].Err)
+	}
+	pids := p.Workers()
+	if pids[0] != rolled[0].PID {
+		t.Errorf("slot 0 runs %d, want the rolled back worker %d", pids[0], rolled[0].PID)
+	}
+	for slot, pid := range pids {
+		fi, err := os.Stat(filepath.Join("/proc", strconv.Itoa(pid), "exe"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !os.SameFile(fi, prev) {
+			t.Errorf("worker %d in slot %d does not run the previous binary", pid, slot)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+//go:build unix
+
+package again_test
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/TykTechnologies/again"
+)
+
+// TestMain runs the test binary as a pool worker when it was started as one:
+// it waits for SIGTERM or SIGQUIT and exits.
+func TestMain(m *testing.M) {
+	if _, ok := again.WorkerID(); ok {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGTERM, syscall.SIGQUIT)
+		<-c
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// started waits until every slot of p has a worker.
+func started(t *testing.T, p *again.Pool) []int {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		pids := p.Workers()
+		running := 0
+		for _, pid := range pids {
+			if pid != 0 {
+				running++
+			}
+		}
+		if running == len(pids) {
+			return pids
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("workers %v did not start", pids)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStopPool(t *testing.T) {
+	a := again.New(again.WithSignalSource(make(chan os.Signal)))
+	p := again.NewPool(&a, 1)
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	pid := started(t, p)[0]
+	done := make(chan again.Result, 1)
+	go func() { done <- again.WaitResult(&a) }()
+	a.Stop()
+	select {
+	case r := <-done:
+		if !errors.Is(r.Err, again.ErrStopped) {
+			t.Errorf("Wait returned %v, want %v", r.Err, again.ErrStopped)
+		}
+	case <-time.After(10 * time.Second):
+		p.Stop(syscall.SIGKILL)
+		t.Fatal("Wait did not return after Stop")
+	}
+	if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+		t.Errorf("worker %d still exists: %v", pid, err)
+	}
+}
+
+// TestRollingRestartOldWorkerDies checks that a slot whose old worker died
+// while its replacement was health checked gets a worker again when the
+// replacement fails.
+func TestRollingRestartOldWorkerDies(t *testing.T) {
+	a := again.New(again.WithSignalSource(make(chan os.Signal)))
+	p := again.NewPool(&a, 1)
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop(syscall.SIGTERM)
+	old := started(t, p)[0]
+	var replacement int
+	p.HealthTimeout = time.Second
+	p.HealthCheck = func(pid, slot int) error {
+		replacement = pid
+		syscall.Kill(old, syscall.SIGKILL)
+		// Outlast the restart delay, so the old worker is not restarted
+		// on its own.
+		time.Sleep(1500 * time.Millisecond)
+		return errors.New("unhealthy")
+	}
+	if err := p.RollingRestart(); err == nil {
+		t.Fatal("rolling restart with an unhealthy worker succeeded")
+	}
+	pid := started(t, p)[0]
+	if pid == old || pid == replacement {
+		t.Fatalf("slot runs worker %d, want a new one", pid)
+	}
+	if err := syscall.Kill(pid, 0); err != nil {
+		t.Fatalf("worker %d: %v", pid, err)
+	}
+}
+
+// events records the events of an instance.
+type events struct {
+	mu sync.Mutex
+	ev []again.Event
+}
+
+func (e *events) add(ev again.Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ev = append(e.ev, ev)
+}
+
+// find returns the events of type typ for slot.
+func (e *events) find(typ again.EventType, slot int) []again.Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var found []again.Event
+	for _, ev := range e.ev {
+		if ev.Type == typ && ev.Worker == slot {
+			found = append(found, ev)
+		}
+	}
+	return found
+}
+
+// unhealthy is a Pool.HealthCheck failing the worker in slot.
+func unhealthy(slot int) func(pid, slot int) error {
+	bad := slot
+	return func(pid, slot int) error {
+		if slot == bad {
+			return errors.New("unhealthy")
+		}
+		return nil
+	}
+}
+
+// TestRollbackWithoutPrevious checks that a slot stays with its new worker
+// when a failed rolling restart has no previous binary to roll back to.
+func TestRollbackWithoutPrevious(t *testing.T) {
+	var ev events
+	a := again.New(
+		again.WithSignalSource(make(chan os.Signal)),
+		again.WithEventHandler(ev.add),
+	)
+	p := again.NewPool(&a, 2)
+	defer p.Stop(syscall.SIGTERM)
+	p.HealthTimeout = 200 * time.Millisecond
+	p.HealthCheck = unhealthy(1)
+	if err := p.RollingRestart(); !errors.Is(err, again.ErrChildFailed) {
+		t.Fatalf("rolling restart returned %v, want %v", err, again.ErrChildFailed)
+	}
+	if len(ev.find(again.EventUpgradeAborted, 1)) != 1 {
+		t.Error("no EventUpgradeAborted for slot 1")
+	}
+	failed := ev.find(again.EventRollbackFailed, 0)
+	if len(failed) != 1 || failed[0].Err == nil {
+		t.Fatalf("got %+v, want one EventRollbackFailed with an error for slot 0", failed)
+	}
+	upgraded := ev.find(again.EventWorkerUpgraded, 0)
+	if len(upgraded) != 1 {
+		t.Fatalf("got %+v, want one EventWorkerUpgraded for slot 0", upgraded)
+	}
+	if pids := p.Workers(); pids[0] != upgraded[0].PID || pids[1] != 0 {
+		t.Errorf("workers %v, want slot 0 to keep worker %d and slot 1 empty", pids, upgraded[0].PID)
+	}
+}
@@ -0,0 +1,57 @@
+package again
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+)
+
+// WithDropPrivileges makes ListenFrom switch to the given user and group once
+// the inherited listeners have been rebuilt, see DropPrivileges. If dropping
+// fails ListenFrom returns the error, so the child never serves with more
+// privileges than intended.
+func WithDropPrivileges(username, group string) Option {
+	return optionFunc(func(a *Again) {
+		a.dropUser, a.dropGroup = username, group
+	})
+}
+
+// DropPrivileges switches the process to username and group, which may be
+// names or numeric IDs; an empty group selects the primary group of the user.
+// Supplementary groups are replaced, then the group and finally the user is
+// set. Afterwards it verifies that the IDs took effect and that root can't be
+// regained, and returns an error otherwise. Call it after all privileged
+// listeners have been bound or inherited.
+func DropPrivileges(username, group string) error {
+	uid, gid, err := lookupIDs(username, group)
+	if err != nil {
+		return err
+	}
+	return setIDs(uid, gid)
+}
+
+func lookupIDs(username, group string) (uid, gid int, err error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		if u, err = user.LookupId(username); err != nil {
+			return 0, 0, fmt.Errorf("again: unknown user %q: %w", username, err)
+		}
+	}
+	if uid, err = strconv.Atoi(u.Uid); err != nil {
+		return 0, 0, err
+	}
+	gidStr := u.Gid
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return 0, 0, fmt.Errorf("again: unknown group %q: %w", group, err)
+			}
+		}
+		gidStr = g.Gid
+	}
+	if gid, err = strconv.Atoi(gidStr); err != nil {
+		return 0, 0, err
+	}
+	return uid, gid, nil
+}
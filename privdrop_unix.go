@@ -0,0 +1,34 @@
+//go:build unix
+
+package again
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// setIDs switches to uid and gid for DropPrivileges. It sticks to syscall:
+// on Linux its setters apply to all threads, those of x/sys/unix only to the
+// calling one.
+func setIDs(uid, gid int) error {
+	if syscall.Getuid() == uid && syscall.Geteuid() == uid && syscall.Getgid() == gid {
+		return nil
+	}
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("again: setgroups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("again: setgid %d: %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("again: setuid %d: %w", uid, err)
+	}
+	if syscall.Getuid() != uid || syscall.Geteuid() != uid ||
+		syscall.Getgid() != gid || syscall.Getegid() != gid {
+		return fmt.Errorf("again: privileges not dropped to %d:%d", uid, gid)
+	}
+	if uid != 0 && syscall.Setuid(0) == nil {
+		return fmt.Errorf("again: root privileges could be regained after dropping to %d", uid)
+	}
+	return nil
+}
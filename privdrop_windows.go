@@ -0,0 +1,8 @@
+package again
+
+import "syscall"
+
+// setIDs fails, Windows has no user and group IDs to switch to.
+func setIDs(uid, gid int) error {
+	return syscall.EWINDOWS
+}
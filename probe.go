@@ -0,0 +1,49 @@
+package again
+
+import (
+	"context"
+	"time"
+)
+
+// Ready reports whether this process should receive new traffic: it is
+// serving, possibly while a child is starting, and hasn't begun draining.
+// Use it for readiness probes.
+func (a *Again) Ready() bool {
+	switch a.State() {
+	case Serving, Upgrading:
+		return true
+	}
+	return false
+}
+
+// Live reports whether this process is healthy. It stays true throughout an
+// upgrade in both generations, including while the old one drains, so a
+// liveness probe never restarts a container because of a handoff.
+func (a *Again) Live() bool {
+	return a.State() != Stopped
+}
+
+// WithDrainDelay makes DrainAndWait keep accepting for d after readiness
+// turned false, giving load balancers time to stop sending new connections.
+func WithDrainDelay(d time.Duration) Option {
+	return optionFunc(func(a *Again) {
+		a.drainDelay = d
+	})
+}
+
+// DrainAndWait is meant to be called from a Kubernetes preStop hook. It
+// switches to Draining, so Ready returns false, keeps accepting for the
+// delay set by WithDrainDelay and then shuts down like ShutdownContext,
+// returning once all connections are closed or ctx is done.
+func (a *Again) DrainAndWait(ctx context.Context) error {
+	a.setState(Draining)
+	if a.drainDelay > 0 {
+		t := time.NewTimer(a.drainDelay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+	return a.ShutdownContext(ctx)
+}
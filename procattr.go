@@ -0,0 +1,59 @@
+package again
+
+import (
+	"os"
+	"strings"
+)
+
+// WithProcAttr registers fn to adjust the attributes of every process started
+// by ForkExec or a Pool just before it is started. Use it to set credentials
+// (drop root after inheriting a privileged port), start a new session, enter
+// namespaces, set Pdeathsig and so on. fn must not change attr.Files.
+func WithProcAttr(fn func(attr *os.ProcAttr)) Option {
+	return optionFunc(func(a *Again) {
+		a.procAttr = append(a.procAttr, fn)
+	})
+}
+
+// WithChildEnv adds or overrides environment variables of the next
+// generation, e.g. to point it at a new config file. The variables
+// describing the handoff always take precedence.
+func WithChildEnv(env map[string]string) Option {
+	return optionFunc(func(a *Again) {
+		if a.childEnv == nil {
+			a.childEnv = make(map[string]string)
+		}
+		for k, v := range env {
+			a.childEnv[k] = v
+		}
+	})
+}
+
+// WithChildArgs registers fn to compute the arguments of the next generation
+// from the current os.Args, including the program name in args[0].
+func WithChildArgs(fn func(args []string) []string) Option {
+	return optionFunc(func(a *Again) {
+		a.childArgs = fn
+	})
+}
+
+// extraEnv returns the variables set with WithChildEnv except those of the
+// handoff protocol.
+func (a *Again) extraEnv() map[string]string {
+	env := make(map[string]string, len(a.childEnv))
+	for k, v := range a.childEnv {
+		if !strings.HasPrefix(k, a.envName("")) {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// argv returns the arguments for the next generation.
+func (a *Again) argv() []string {
+	args := append([]string(nil), os.Args...)
+	if a.childArgs != nil {
+		args = a.childArgs(args)
+	}
+	return args
+}
@@ -0,0 +1,28 @@
+package again
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// procStart returns the start time of pid in clock ticks after boot, which
+// tells a process from a later one with the same PID.
+func procStart(pid int) (uint64, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// The command in field 2 may contain anything but ends at the last ')';
+	// starttime is field 22.
+	i := bytes.LastIndexByte(b, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("again: malformed /proc/%d/stat", pid)
+	}
+	fields := bytes.Fields(b[i+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("again: malformed /proc/%d/stat", pid)
+	}
+	return strconv.ParseUint(string(fields[19]), 10, 64)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package again
+
+import "errors"
+
+// procStart is only implemented on Linux; verifyTarget falls back to the
+// parent PID elsewhere.
+func procStart(pid int) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,104 @@
+package again
+
+import (
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// rawSocket stores a socket that is neither a listener nor a
+// net.PacketConn, e.g. an AF_PACKET socket, as the Listener of a Service.
+// Accept blocks until it is closed.
+type rawSocket struct {
+	f      *os.File
+	addr   rawAddr
+	once   sync.Once
+	closed chan struct{}
+}
+
+// rawAddr is the address of a raw socket: the name of its service.
+type rawAddr string
+
+func (rawAddr) Network() string  { return "raw" }
+func (a rawAddr) String() string { return string(a) }
+
+func newRawSocket(name string, f *os.File) *rawSocket {
+	return &rawSocket{f: f, addr: rawAddr(name), closed: make(chan struct{})}
+}
+
+func (l *rawSocket) Accept() (net.Conn, error) {
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *rawSocket) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return l.f.Close()
+}
+
+func (l *rawSocket) Addr() net.Addr { return l.addr }
+
+func (l *rawSocket) Fd() (uintptr, error) { return l.f.Fd(), nil }
+
+func (l *rawSocket) SyscallConn() (syscall.RawConn, error) { return l.f.SyscallConn() }
+
+// ListenRaw registers the socket f, e.g. an AF_PACKET socket with a BPF
+// filter attached, as the service name so it is handed to the next
+// generation instead of being set up again. Filters attached to the socket
+// stay attached; anything that belongs to the process, like a mapped
+// PACKET_RX_RING, is redone by the function registered with WithRawSetup.
+// The service owns f from now on.
+func (a *Again) ListenRaw(name string, f *os.File, opts ...RegisterOption) error {
+	l := newRawSocket(name, f)
+	s := &Service{
+		Name:       name,
+		FdName:     ListerName(l),
+		Listener:   l,
+		Descriptor: f.Fd(),
+	}
+	return a.add(s, opts)
+}
+
+// WithRawSetup registers fn to be called with the raw socket of the named
+// service when it is inherited, before ListenFrom returns. An error fails
+// the service like any other inheritance error.
+func WithRawSetup(name string, fn func(f *os.File) error) Option {
+	return optionFunc(func(a *Again) {
+		if a.rawSetup == nil {
+			a.rawSetup = make(map[string]func(*os.File) error)
+		}
+		a.rawSetup[name] = fn
+	})
+}
+
+// File returns the socket of a service registered with ListenRaw, or nil
+// for other services.
+func (s *Service) File() *os.File {
+	if l, ok := s.Listener.(*rawSocket); ok {
+		return l.f
+	}
+	return nil
+}
+
+// GetFile returns the socket of the named raw service or nil.
+func (a Again) GetFile(key string) *os.File {
+	if s := a.Get(key); s != nil {
+		return s.File()
+	}
+	return nil
+}
+
+// rawCodec transfers raw sockets.
+type rawCodec struct{}
+
+func (rawCodec) Export(l net.Listener) (*os.File, error) { return exportFd(l) }
+
+func (rawCodec) Import(f *os.File) (net.Listener, error) {
+	fd, err := sysOS{}.DupCloexec(f.Fd())
+	if err != nil {
+		return nil, err
+	}
+	_, name := parseFdName(f.Name())
+	return newRawSocket(name, os.NewFile(fd, f.Name())), nil
+}
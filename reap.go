@@ -0,0 +1,106 @@
+package again
+
+import (
+	"log/slog"
+	"os"
+	"time"
+)
+
+// reapInterval is how often the reaper looks for zombies even without
+// SIGCHLD, which coalesces.
+const reapInterval = time.Second
+
+// WithReaper makes Wait reap every exited child process, as an init process
+// has to, so running as PID 1 in a container doesn't need tini just because
+// of again. Reaping is on when this process is PID 1 or subreaper is true;
+// subreaper also makes this process a child subreaper, so orphaned
+// descendants, e.g. an old generation whose parent exited, are re-parented
+// to it. Pool workers are left to the Pool; other children started with
+// os/exec may be reaped before their Wait sees them. Reaping is only
+// supported on Linux; elsewhere Wait logs a warning and reaps nothing.
+func WithReaper(subreaper bool) Option {
+	return optionFunc(func(a *Again) {
+		a.reap = subreaper || os.Getpid() == 1
+		a.subreaper = subreaper
+	})
+}
+
+// startReaping starts reaping zombies if configured and returns a function
+// stopping it.
+func (a *Again) startReaping() func() {
+	if !a.reap {
+		return func() {}
+	}
+	if !reapSupported {
+		a.log(slog.LevelWarn, "again: reaping is only supported on Linux")
+		return func() {}
+	}
+	if a.subreaper {
+		if err := setSubreaper(); err != nil {
+			a.log(slog.LevelError, "again: subreaper", "err", err)
+		}
+	}
+	ch := make(chan os.Signal, 1)
+	stop := muxSignals.subscribe(ch, 0, nil, sigCHLD)
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(reapInterval)
+		defer t.Stop()
+		for {
+			a.reapZombies()
+			select {
+			case <-ch:
+			case <-t.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		stop()
+		close(done)
+	}
+}
+
+// reapZombies reaps exited children until there are none left or the next
+// one is a Pool worker or awaited by someone else.
+func (a *Again) reapZombies() {
+	a.lc.reaping.Lock()
+	defer a.lc.reaping.Unlock()
+	workers := make(map[int]bool)
+	if p := a.pool(); p != nil {
+		for _, pid := range p.Workers() {
+			workers[pid] = true
+		}
+	}
+	a.lc.mu.Lock()
+	for pid := range a.lc.awaited {
+		workers[pid] = true
+	}
+	a.lc.mu.Unlock()
+	for {
+		pid, err := exitedChild()
+		if err != nil || pid == 0 || workers[pid] {
+			return
+		}
+		if err := reapPid(pid); err != nil {
+			return
+		}
+	}
+}
+
+// await keeps the reaper away from the child pid until the returned function
+// is called, so its status is left to waitPid.
+func (a *Again) await(pid int) func() {
+	a.lc.mu.Lock()
+	if a.lc.awaited == nil {
+		a.lc.awaited = make(map[int]bool)
+	}
+	a.lc.awaited[pid] = true
+	a.lc.mu.Unlock()
+	return func() {
+		a.lc.mu.Lock()
+		delete(a.lc.awaited, pid)
+		a.lc.mu.Unlock()
+	}
+}
@@ -0,0 +1,28 @@
+package again
+
+import "golang.org/x/sys/unix"
+
+// wnohang is WNOHANG of <sys/wait.h>, which x/sys/unix doesn't define for
+// AIX.
+const wnohang = 0x1
+
+// reapSupported tells whether exitedChild works: without waitid the reaper
+// can't tell Pool workers apart.
+const reapSupported = false
+
+// setSubreaper is only supported on Linux.
+func setSubreaper() error {
+	return unix.ENOTSUP
+}
+
+// exitedChild is only supported on Linux.
+func exitedChild() (int, error) {
+	return 0, nil
+}
+
+// reapPid reaps the exited child pid.
+func reapPid(pid int) error {
+	var ws unix.WaitStatus
+	_, err := unix.Wait4(pid, &ws, wnohang, nil)
+	return err
+}
@@ -0,0 +1,41 @@
+package again
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// reapSupported tells whether exitedChild works.
+const reapSupported = true
+
+// setSubreaper marks this process as a child subreaper.
+func setSubreaper() error {
+	return unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+}
+
+// exitedChild returns the PID of an exited child without reaping it, or 0 if
+// there is none.
+func exitedChild() (int, error) {
+	// unix.Siginfo hides si_pid, which follows three ints padded to pointer
+	// alignment.
+	const pidOffset = (12 + unsafe.Sizeof(uintptr(0)) - 1) &^ (unsafe.Sizeof(uintptr(0)) - 1)
+	for {
+		var info unix.Siginfo
+		err := unix.Waitid(unix.P_ALL, 0, &info, unix.WEXITED|unix.WNOHANG|unix.WNOWAIT, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return int(*(*int32)(unsafe.Add(unsafe.Pointer(&info), pidOffset))), nil
+	}
+}
+
+// reapPid reaps the exited child pid.
+func reapPid(pid int) error {
+	var ws unix.WaitStatus
+	_, err := unix.Wait4(pid, &ws, unix.WNOHANG, nil)
+	return err
+}
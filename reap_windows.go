@@ -0,0 +1,18 @@
+package again
+
+import "syscall"
+
+// Windows has no zombies to reap.
+const reapSupported = false
+
+func setSubreaper() error {
+	return syscall.EWINDOWS
+}
+
+func exitedChild() (int, error) {
+	return 0, nil
+}
+
+func reapPid(pid int) error {
+	return nil
+}
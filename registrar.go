@@ -0,0 +1,53 @@
+package again
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Registrar registers services with a service discovery system such as
+// Consul, etcd or ZooKeeper.
+type Registrar interface {
+	Register(ctx context.Context, s *Service) error
+	Deregister(ctx context.Context, s *Service) error
+}
+
+// WithRegistrar makes the lifecycle drive r: all services are registered
+// when Wait starts serving and deregistered before draining starts, or before
+// Wait returns without draining. Each call gets a context with timeout;
+// failures are logged. Since the next generation registers itself once it
+// is serving, an upgrade moves the registration from the old process to the
+// new one.
+func WithRegistrar(r Registrar, timeout time.Duration) Option {
+	return optionFunc(func(a *Again) {
+		a.registrar = r
+		a.registrarTimeout = timeout
+	})
+}
+
+// register updates the registrar for the transition from prev to s.
+func (a *Again) register(prev, s State) {
+	if a.registrar == nil {
+		return
+	}
+	var fn func(context.Context, *Service) error
+	switch {
+	case prev == Starting && s == Serving:
+		fn = a.registrar.Register
+	case s == Draining, s == Stopped && prev != Draining:
+		fn = a.registrar.Deregister
+	default:
+		return
+	}
+	for _, svc := range a.list() {
+		ctx, cancel := context.Background(), func() {}
+		if a.registrarTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, a.registrarTimeout)
+		}
+		if err := fn(ctx, svc); err != nil {
+			a.log(slog.LevelError, "again: registrar", "service", svc.Name, "err", err)
+		}
+		cancel()
+	}
+}
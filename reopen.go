@@ -0,0 +1,106 @@
+package again
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+// Reopener is implemented by log outputs that reopen their file after it was
+// moved away by log rotation.
+type Reopener interface {
+	Reopen() error
+}
+
+// WithReopen registers rs to be reopened on SIGUSR1, before the OnSIGUSR1
+// hooks run, which is when logrotate and similar tools expect it.
+func WithReopen(rs ...Reopener) Option {
+	return optionFunc(func(a *Again) {
+		a.reopeners = append(a.reopeners, rs...)
+	})
+}
+
+// reopen reopens the registered Reopeners and returns the joined errors.
+func (a *Again) reopen() error {
+	var errs []error
+	for _, r := range a.reopeners {
+		errs = append(errs, r.Reopen())
+	}
+	return errors.Join(errs...)
+}
+
+// LogFile is a log file opened for appending that can be reopened at the
+// same path. It is safe for concurrent use; writes go either to the old or
+// the new file, never get lost while it is swapped.
+type LogFile struct {
+	path string
+	perm os.FileMode
+
+	mu sync.RWMutex
+	f  *os.File
+}
+
+// OpenLogFile opens path for appending, creating it with perm if needed.
+func OpenLogFile(path string, perm os.FileMode) (*LogFile, error) {
+	l := &LogFile{path: path, perm: perm}
+	f, err := l.open()
+	if err != nil {
+		return nil, err
+	}
+	l.f = f
+	return l, nil
+}
+
+func (l *LogFile) open() (*os.File, error) {
+	return os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, l.perm)
+}
+
+func (l *LogFile) Write(b []byte) (int, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.f.Write(b)
+}
+
+// Reopen opens the path again and closes the previous file once pending
+// writes are done. If opening fails the previous file is kept.
+func (l *LogFile) Reopen() error {
+	f, err := l.open()
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	old := l.f
+	l.f = f
+	l.mu.Unlock()
+	return old.Close()
+}
+
+// Close closes the file.
+func (l *LogFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// LogStdio returns a StdioFunc that opens stdout and stderr of the next
+// generation at the paths of the given log files, so it writes to the files
+// in place after the last rotation. A nil LogFile leaves the stream at
+// /dev/null; stdin is /dev/null.
+func LogStdio(stdout, stderr *LogFile) StdioFunc {
+	return func() (_, out, errf *os.File, err error) {
+		if stdout != nil {
+			if out, err = stdout.open(); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		if stderr != nil {
+			if errf, err = stderr.open(); err != nil {
+				if out != nil {
+					out.Close()
+				}
+				return nil, nil, nil, err
+			}
+		}
+		return nil, out, errf, nil
+	}
+}
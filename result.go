@@ -0,0 +1,41 @@
+package again
+
+import "syscall"
+
+// Result describes why WaitResult returned.
+type Result struct {
+	// Signal is the signal that ended Wait, 0 after Stop.
+	Signal syscall.Signal
+	// Source is "signal" for a process signal, "trigger" for Trigger,
+	// "handoff" for a child answering over the handoff socket, "pipe" for
+	// WithControlPipe, "watch" for WithUpgradeWatch, "schedule" for
+	// WithRestartSchedule and "stop" for Stop.
+	Source string
+	// Child is the last generation spawned by this process, if any.
+	Child ChildInfo
+	// Conns are the connection statistics over all services when Wait
+	// returned; Conns.Active is what was still open after the hooks ran.
+	Conns Stats
+	// Err joins the errors of the hooks run for the signal, of stopping a
+	// Pool and of the upgrade, or is ErrStopped.
+	Err error
+}
+
+// stats returns the connection statistics summed over all services, with
+// the oldest connection of any.
+func (a *Again) stats() Stats {
+	var st Stats
+	a.Range(func(s *Service) {
+		ss := s.Stats()
+		st.Active += ss.Active
+		st.Total += ss.Total
+		st.BytesRead += ss.BytesRead
+		st.BytesWritten += ss.BytesWritten
+		st.Expired += ss.Expired
+		st.Rejected += ss.Rejected
+		if ss.Oldest > st.Oldest {
+			st.Oldest = ss.Oldest
+		}
+	})
+	return st
+}
@@ -0,0 +1,52 @@
+package again
+
+import (
+	"net"
+	"syscall"
+)
+
+// reusePortEnv tells a child started by StrategyReusePort to bind the
+// services afresh instead of inheriting descriptors.
+const reusePortEnv = "REUSEPORT"
+
+// bindReusePort binds the address recorded for s with SO_REUSEPORT, next to
+// the listener of the parent, running the Control of the ListenConfig of s
+// first if it has one.
+func (a *Again) bindReusePort(s *Service) error {
+	network, addr := parseFdName(s.FdName)
+	if network == "" {
+		return &FdError{Service: s.Name, Fd: s.Descriptor, Reason: "no address recorded"}
+	}
+	var lc net.ListenConfig
+	if s.lc != nil {
+		lc = *s.lc
+	}
+	control := lc.Control
+	lc.Control = func(network, address string, c syscall.RawConn) error {
+		if control != nil {
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			serr = setReusePort(fd)
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}
+	l, err := listen(&lc, network, addr)
+	if err != nil {
+		return err
+	}
+	fd, err := listenerFd(l)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	s.Listener = l
+	s.Descriptor = fd
+	return a.restoreSockOpts(s)
+}
@@ -0,0 +1,8 @@
+package again
+
+import "syscall"
+
+// setReusePort fails, Solaris and illumos have no SO_REUSEPORT.
+func setReusePort(fd uintptr) error {
+	return syscall.ENOPROTOOPT
+}
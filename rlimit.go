@@ -0,0 +1,56 @@
+package again
+
+import "fmt"
+
+// nofileHeadroom is the number of descriptors reserved for the child on top
+// of stdio and the inherited listeners.
+const nofileHeadroom = 32
+
+// WithNofileCheck makes upgrades check RLIMIT_NOFILE before starting the next
+// generation, which receives its listeners as descriptors 3 and up. If the
+// soft limit is too low it is raised up to the hard limit when raise is set;
+// otherwise, or if the hard limit is too low as well, the upgrade fails with
+// a *RlimitError.
+func WithNofileCheck(raise bool) Option {
+	return optionFunc(func(a *Again) {
+		a.nofileCheck = true
+		a.nofileRaise = raise
+	})
+}
+
+// RlimitError reports that RLIMIT_NOFILE is too low to hand all listeners to
+// the next generation.
+type RlimitError struct {
+	Need uint64
+	Soft uint64
+	Hard uint64
+}
+
+func (e *RlimitError) Error() string {
+	return fmt.Sprintf(
+		"again: RLIMIT_NOFILE too low for upgrade: need %d, soft limit %d, hard limit %d",
+		e.Need, e.Soft, e.Hard,
+	)
+}
+
+// checkNofile makes sure n listeners can be passed to a child.
+func (a *Again) checkNofile(n int) error {
+	if !a.nofileCheck {
+		return nil
+	}
+	need := uint64(3 + n + nofileHeadroom)
+	soft, hard, err := nofile()
+	if err != nil {
+		return err
+	}
+	if soft >= need {
+		return nil
+	}
+	if !a.nofileRaise || hard < need {
+		return &RlimitError{Need: need, Soft: soft, Hard: hard}
+	}
+	if err := setNofile(need, hard); err != nil {
+		return fmt.Errorf("%w: %v", &RlimitError{Need: need, Soft: soft, Hard: hard}, err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build unix && !freebsd && !dragonfly
+
+package again
+
+import "golang.org/x/sys/unix"
+
+// nofile returns the soft and hard RLIMIT_NOFILE.
+func nofile() (soft, hard uint64, err error) {
+	var rl unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, 0, err
+	}
+	return rl.Cur, rl.Max, nil
+}
+
+// setNofile sets RLIMIT_NOFILE.
+func setNofile(soft, hard uint64) error {
+	return unix.Setrlimit(unix.RLIMIT_NOFILE, &unix.Rlimit{Cur: soft, Max: hard})
+}
@@ -0,0 +1,12 @@
+package again
+
+import "math"
+
+// nofile reports no limit, Windows has no RLIMIT_NOFILE.
+func nofile() (soft, hard uint64, err error) {
+	return math.MaxUint64, math.MaxUint64, nil
+}
+
+func setNofile(soft, hard uint64) error {
+	return nil
+}
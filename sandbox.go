@@ -0,0 +1,82 @@
+package again
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrSandbox is wrapped by the error EnterSandbox returns when the sandbox
+// forbids something again needs to upgrade later.
+var ErrSandbox = errors.New("again: sandbox forbids upgrades")
+
+// WithSandbox makes ListenFrom and Adopt call fn to restrict the process,
+// e.g. by installing seccomp filters or Landlock rules, once the listeners
+// have been inherited and privileges dropped, before the warmup and before
+// anything is served. See EnterSandbox for what the restrictions have to
+// leave alone.
+func WithSandbox(fn func() error) Option {
+	return optionFunc(func(a *Again) {
+		a.sandbox = fn
+	})
+}
+
+// EnterSandbox calls the function set with WithSandbox, which ListenFrom
+// does in a child; call it in the first generation once its listeners are
+// registered. Afterwards it checks that again can still do what upgrades
+// and handoffs need and fails with ErrSandbox otherwise: stat and read the
+// binary to upgrade to and its checksum file, find the working directory
+// of the next generation, duplicate descriptors, create pipes and, with
+// WithSocketHandoff or WithConnHandoff, unix socket pairs, and signal the
+// parent. Let filters return an error rather than kill the process so the
+// check can report them. Beyond that again needs fork or clone, execve,
+// wait4, kill and signal handling, plus the system calls of any options in
+// use that it can't try without side effects, e.g. bind for
+// WithControlSocket or writing the file of WithAuditLog.
+func (a *Again) EnterSandbox() error {
+	if a.sandbox == nil {
+		return nil
+	}
+	if err := a.sandbox(); err != nil {
+		return fmt.Errorf("again: sandbox: %w", err)
+	}
+	return a.checkSandbox()
+}
+
+// checkSandbox tries what upgrades need.
+func (a *Again) checkSandbox() error {
+	argv0, err := a.binary()
+	if err != nil {
+		return fmt.Errorf("%w: binary: %v", ErrSandbox, err)
+	}
+	if _, err := a.childWd(argv0); err != nil {
+		return fmt.Errorf("%w: working directory: %v", ErrSandbox, err)
+	}
+	if services := a.list(); len(services) > 0 {
+		fd, err := a.sys.DupCloexec(services[0].Descriptor)
+		if err != nil {
+			return fmt.Errorf("%w: duplicating descriptors: %v", ErrSandbox, err)
+		}
+		os.NewFile(fd, "").Close()
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("%w: pipe: %v", ErrSandbox, err)
+	}
+	r.Close()
+	w.Close()
+	if a.socketHandoff || a.connHandoff {
+		uc, f, err := socketPair()
+		if err != nil {
+			return fmt.Errorf("%w: socket pair: %v", ErrSandbox, err)
+		}
+		uc.Close()
+		f.Close()
+	}
+	if a.ppid != 0 && a.ppid == os.Getppid() {
+		if err := a.sys.Kill(a.ppid, 0); err != nil {
+			return fmt.Errorf("%w: signalling the parent: %v", ErrSandbox, err)
+		}
+	}
+	return nil
+}
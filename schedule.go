@@ -0,0 +1,139 @@
+package again
+
+import (
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// Schedule decides when to restart next. It matches the Schedule of
+// github.com/robfig/cron, so cron expressions parsed with it can be used.
+type Schedule interface {
+	// Next returns the restart time following t.
+	Next(t time.Time) time.Time
+}
+
+// Every returns a Schedule restarting d after the previous restart.
+func Every(d time.Duration) Schedule {
+	return every(d)
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// Daily returns a Schedule restarting every day at hour:min local time.
+func Daily(hour, min int) Schedule {
+	return daily{hour: hour, min: min}
+}
+
+type daily struct {
+	hour, min int
+}
+
+func (d daily) Next(t time.Time) time.Time {
+	n := time.Date(t.Year(), t.Month(), t.Day(), d.hour, d.min, 0, 0, t.Location())
+	if !n.After(t) {
+		n = n.AddDate(0, 0, 1)
+	}
+	return n
+}
+
+// Window is a daily period without scheduled restarts, from From to To
+// after local midnight. A window with To before From spans midnight.
+type Window struct {
+	From, To time.Duration
+}
+
+// end returns the end of the window containing t, or false if none does.
+func (w Window) end(t time.Time) (time.Time, bool) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	off := t.Sub(midnight)
+	switch {
+	case w.From <= w.To && off >= w.From && off < w.To:
+		return midnight.Add(w.To), true
+	case w.From > w.To && off >= w.From:
+		return midnight.AddDate(0, 0, 1).Add(w.To), true
+	case w.From > w.To && off < w.To:
+		return midnight.Add(w.To), true
+	}
+	return time.Time{}, false
+}
+
+// restartSchedule is the configuration of WithRestartSchedule.
+type restartSchedule struct {
+	schedule Schedule
+	jitter   time.Duration
+	blackout []Window
+}
+
+// WithRestartSchedule makes Wait restart gracefully on schedule, as if it
+// got SIGUSR2, e.g. nightly to get rid of fragmentation or leaks. Each
+// restart is delayed by a random duration up to jitter, so a fleet doesn't
+// restart at once, and postponed to the end of any blackout window it falls
+// into. EventRestartScheduled reports when the next one is due. A restart
+// due while an upgrade is running is skipped.
+func WithRestartSchedule(s Schedule, jitter time.Duration, blackout ...Window) Option {
+	return optionFunc(func(a *Again) {
+		a.schedule = &restartSchedule{schedule: s, jitter: jitter, blackout: blackout}
+	})
+}
+
+// next returns the restart following now.
+func (rs *restartSchedule) next(now time.Time) time.Time {
+	t := rs.schedule.Next(now)
+	if rs.jitter > 0 {
+		t = t.Add(time.Duration(rand.Int63n(int64(rs.jitter))))
+	}
+	// Windows may touch each other, but they can't cover more than a day.
+	for i := 0; i <= len(rs.blackout); i++ {
+		moved := false
+		for _, w := range rs.blackout {
+			if end, ok := w.end(t); ok {
+				t, moved = end, true
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+	return t
+}
+
+// startSchedule restarts on schedule, if configured, until the returned
+// function is called.
+func (a *Again) startSchedule() func() {
+	rs := a.schedule
+	if rs == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			now := time.Now()
+			next := rs.next(now)
+			if next.IsZero() {
+				return
+			}
+			a.emit(Event{Type: EventRestartScheduled, Duration: next.Sub(now)})
+			t := time.NewTimer(next.Sub(now))
+			select {
+			case <-t.C:
+			case <-done:
+				t.Stop()
+				return
+			}
+			if a.State() != Serving {
+				a.log(slog.LevelInfo, "again: skipping scheduled restart", "state", a.State())
+				continue
+			}
+			a.log(slog.LevelInfo, "again: scheduled restart")
+			if !a.deliver(SIGUSR2, "schedule") {
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
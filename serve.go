@@ -0,0 +1,65 @@
+package again
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Accept retry delays of Serve.
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// Serve accepts connections on s and calls handle for each in a new
+// goroutine, closing the connection when handle returns so connection
+// tracking stays accurate. Temporary errors such as running out of
+// descriptors (EMFILE) or a client aborting before the accept
+// (ECONNABORTED) are logged and retried with a backoff of up to a second.
+// Serve returns nil once the listener is closed, e.g. on shutdown or when
+// the service was handed to another process, and the accept error
+// otherwise.
+func Serve(s *Service, handle func(net.Conn)) error {
+	var delay time.Duration
+	for {
+		c, err := s.Listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			if !temporary(err) {
+				return &ServiceError{Service: s.Name, Err: err}
+			}
+			if delay == 0 {
+				delay = minAcceptDelay
+			} else if delay *= 2; delay > maxAcceptDelay {
+				delay = maxAcceptDelay
+			}
+			s.owner.log(slog.LevelWarn, "again: accept", "service", s.Name, "err", err, "retry", delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go func() {
+			defer c.Close()
+			handle(c)
+		}()
+	}
+}
+
+// temporary reports whether accepting may succeed if retried after err.
+func temporary(err error) bool {
+	for _, errno := range []syscall.Errno{
+		syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM,
+		syscall.ECONNABORTED, syscall.ECONNRESET, syscall.EINTR,
+	} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,72 @@
+package again
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"syscall"
+)
+
+// WithShim keeps the process started by the supervisor resident as a small
+// shim, for supervisors that only track the original PID. The shim never
+// serves or execs the new code itself: Wait starts the program as the single
+// worker of a Pool, unless NewPool was called already and the application
+// starts it, and from then on
+//
+//   - SIGUSR2 replaces the worker with a rolling restart of the pool,
+//   - the signals mapped to ActionReload and ActionReopen, and those given
+//     to WithSignalForwarding, are forwarded to the worker instead of
+//     running the hooks of the shim,
+//   - SIGQUIT, SIGTERM and SIGINT stop the worker with the same signal
+//     before Wait returns,
+//
+// and a crashed worker is restarted, so the PID seen by the supervisor never
+// changes across upgrades. The worker tells itself apart with WorkerID and
+// calls ListenFrom and Wait as usual.
+func WithShim() Option {
+	return optionFunc(func(a *Again) {
+		a.shim = true
+	})
+}
+
+// startShim starts the worker of a shim, see WithShim.
+func (a *Again) startShim() error {
+	if !a.shim || a.pool() != nil {
+		return nil
+	}
+	if _, ok := workerID(a); ok {
+		return nil
+	}
+	if err := NewPool(a, 1).Start(); err != nil {
+		return fmt.Errorf("again: shim worker: %w", err)
+	}
+	return nil
+}
+
+// shimForward forwards sig to the workers if a is a shim and sig is one of
+// the signals it hands on rather than handles. It reports whether it did.
+func (a *Again) shimForward(sig os.Signal) bool {
+	p := a.pool()
+	if !a.shim || p == nil {
+		return false
+	}
+	s, ok := sig.(syscall.Signal)
+	if !ok || s == syscall.SIGQUIT || sig == SIGUSR2 {
+		return false
+	}
+	forward := false
+	switch a.action(sig) {
+	case ActionReload, ActionReopen:
+		forward = true
+	}
+	for _, f := range a.forward {
+		forward = forward || f == sig
+	}
+	if !forward {
+		return false
+	}
+	if err := p.Signal(s); err != nil {
+		a.log(slog.LevelError, "forwarding signal", "signal", sig, "err", err)
+	}
+	return true
+}
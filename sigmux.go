@@ -0,0 +1,192 @@
+package again
+
+import (
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+)
+
+// signalBuffer is the number of received signals that can be queued while
+// the multiplexer routes the previous one.
+const signalBuffer = 16
+
+// muxSignals routes the signals of the process to every instance and to
+// NotifySignals. Signals belong to the process, so unlike the rest of the
+// package this state is shared by all instances.
+var muxSignals = &signalMux{}
+
+// signalMux registers a channel per signal with signal.Notify and hands
+// each signal to its subscribers by priority, so instances in one process
+// receive it once each and in a defined order. With a channel per signal,
+// a signal nobody wants any more is dropped with signal.Stop, without
+// touching the registrations of the others or of the application.
+type signalMux struct {
+	mu sync.Mutex
+	// c queues the signals received on chans for route.
+	c     chan os.Signal
+	chans map[os.Signal]chan os.Signal
+	subs  []*signalSub
+	seq   int
+}
+
+// signalSub is a channel subscribed to the multiplexer.
+type signalSub struct {
+	c         chan<- os.Signal
+	priority  int
+	seq       int
+	sigs      map[os.Signal]bool
+	exclusive map[os.Signal]bool
+}
+
+// WithSignalPriority orders this instance among the subscribers of the
+// process signals, see NotifySignals. Higher priorities receive a signal
+// first; the default is 0.
+func WithSignalPriority(p int) Option {
+	return optionFunc(func(a *Again) {
+		a.signalPriority = p
+	})
+}
+
+// WithExclusiveSignals makes Wait consume sigs: subscribers after this
+// instance in the order of NotifySignals don't receive them. Use it when
+// several instances share a process but only one should e.g. upgrade on
+// SIGUSR2.
+func WithExclusiveSignals(sigs ...os.Signal) Option {
+	return optionFunc(func(a *Again) {
+		a.exclusive = append(a.exclusive, sigs...)
+	})
+}
+
+// NotifySignals is signal.Notify for applications that share the process
+// with Again instances. Wait subscribes to the signals it handles the same
+// way, and each signal is handed to the subscribers in order of decreasing
+// priority, then in the order they subscribed, until one that consumes it
+// with WithExclusiveSignals. Like signal.Notify it doesn't block sending to
+// c. The returned function ends the subscription.
+func NotifySignals(c chan<- os.Signal, priority int, sigs ...os.Signal) (stop func()) {
+	return muxSignals.subscribe(c, priority, nil, sigs...)
+}
+
+// subscribe makes the multiplexer send sigs to c, without handing the ones
+// in exclusive to later subscribers.
+func (m *signalMux) subscribe(c chan<- os.Signal, priority int, exclusive []os.Signal, sigs ...os.Signal) (stop func()) {
+	s := &signalSub{
+		c:         c,
+		priority:  priority,
+		sigs:      make(map[os.Signal]bool),
+		exclusive: make(map[os.Signal]bool),
+	}
+	for _, sig := range sigs {
+		s.sigs[sig] = true
+	}
+	for _, sig := range exclusive {
+		s.exclusive[sig] = true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.c == nil {
+		m.c = make(chan os.Signal, signalBuffer)
+		m.chans = make(map[os.Signal]chan os.Signal)
+		go m.route()
+	}
+	m.seq++
+	s.seq = m.seq
+	m.subs = append(m.subs, s)
+	sort.SliceStable(m.subs, func(i, j int) bool {
+		if m.subs[i].priority != m.subs[j].priority {
+			return m.subs[i].priority > m.subs[j].priority
+		}
+		return m.subs[i].seq < m.subs[j].seq
+	})
+	for _, sig := range sigs {
+		m.listen(sig)
+	}
+	var once sync.Once
+	return func() { once.Do(func() { m.unsubscribe(s) }) }
+}
+
+// listen starts receiving sig if it isn't yet.
+func (m *signalMux) listen(sig os.Signal) {
+	if m.chans[sig] != nil {
+		return
+	}
+	c := make(chan os.Signal, signalBuffer)
+	m.chans[sig] = c
+	signal.Notify(c, sig)
+	go func() {
+		for received := range c {
+			m.c <- received
+		}
+	}()
+}
+
+// unsubscribe removes s and stops receiving the signals nobody else wants.
+// The others stay registered throughout: even a moment with the default
+// action would let e.g. SIGHUP kill the process.
+func (m *signalMux) unsubscribe(s *signalSub) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, sub := range m.subs {
+		if sub == s {
+			m.subs = append(m.subs[:i], m.subs[i+1:]...)
+			break
+		}
+	}
+	for sig := range s.sigs {
+		if c := m.chans[sig]; c != nil && !m.wanted(sig) {
+			delete(m.chans, sig)
+			// Nothing is sent to c once Stop returned.
+			signal.Stop(c)
+			close(c)
+		}
+	}
+}
+
+// wanted reports whether any subscriber wants sig.
+func (m *signalMux) wanted(sig os.Signal) bool {
+	for _, s := range m.subs {
+		if s.sigs[sig] {
+			return true
+		}
+	}
+	return false
+}
+
+// route hands every received signal to the subscribers.
+func (m *signalMux) route() {
+	for sig := range m.c {
+		m.mu.Lock()
+		for _, s := range m.subs {
+			if !s.sigs[sig] {
+				continue
+			}
+			select {
+			case s.c <- sig:
+			default:
+			}
+			if s.exclusive[sig] {
+				break
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// restore undoes signal.Ignore for sigs: the ones somebody subscribed to are
+// received again, the others get their default action back. Notify undoes
+// Ignore and stopping a channel of our own disables the signal only if
+// nobody else registered one since.
+func (m *signalMux) restore(sigs ...os.Signal) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, sig := range sigs {
+		if c := m.chans[sig]; c != nil {
+			signal.Notify(c, sig)
+			continue
+		}
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, sig)
+		signal.Stop(c)
+	}
+}
@@ -0,0 +1,45 @@
+//go:build unix
+
+package again_test
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/TykTechnologies/again"
+)
+
+// TestSignalsSurviveUnsubscribe checks that the signal.Notify registration
+// of the application outlives the subscriptions of again.
+func TestSignalsSurviveUnsubscribe(t *testing.T) {
+	app := make(chan os.Signal, 1)
+	signal.Notify(app, syscall.SIGHUP)
+	defer signal.Stop(app)
+
+	stop := again.NotifySignals(make(chan os.Signal, 1), 0, syscall.SIGHUP)
+	stop()
+
+	a := again.New()
+	done := make(chan error, 1)
+	go func() {
+		_, err := again.Wait(&a)
+		done <- err
+	}()
+	for a.State() != again.Serving {
+		time.Sleep(time.Millisecond)
+	}
+	a.Stop()
+	if err := <-done; err != again.ErrStopped {
+		t.Fatalf("Wait returned %v, want %v", err, again.ErrStopped)
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	select {
+	case <-app:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the application did not receive SIGHUP")
+	}
+}
@@ -0,0 +1,114 @@
+package again
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// triggerBuffer is the number of triggered signals that can be queued while
+// Wait is busy.
+const triggerBuffer = 8
+
+// WithSignalHandling controls whether Wait subscribes to process signals,
+// see NotifySignals. Disable it when the application owns signal handling
+// and drives the instance with Trigger instead.
+func WithSignalHandling(enabled bool) Option {
+	return optionFunc(func(a *Again) {
+		a.noSignals = !enabled
+	})
+}
+
+// Trigger makes Wait act as if sig was received. It blocks when more than a
+// few triggered signals are queued and Wait isn't running, and does nothing
+// after Stop.
+func (a *Again) Trigger(sig os.Signal) {
+	select {
+	case <-a.lc.stop:
+		return
+	default:
+	}
+	select {
+	case a.lc.trigger <- sig:
+	case <-a.lc.stop:
+	}
+}
+
+// sourcedSignal is a signal raised by again itself and what raised it.
+type sourcedSignal struct {
+	sig    os.Signal
+	source string
+}
+
+// deliver hands sig to Wait, reporting source as what delivered it. It
+// returns false if Stop was called instead.
+func (a *Again) deliver(sig os.Signal, source string) bool {
+	select {
+	case a.lc.internal <- sourcedSignal{sig, source}:
+		return true
+	case <-a.lc.stop:
+		return false
+	}
+}
+
+// Stop makes Wait unregister its signal handler and return ErrStopped
+// without running any signal hooks, now or, if it isn't running, when it is
+// called. Listeners and connections are left alone, close them with Close or
+// ShutdownContext. The workers of a Pool are stopped with SIGTERM. Use it to
+// tear an instance down in tests or when again is embedded in a larger
+// program.
+func (a *Again) Stop() {
+	a.lc.stopOnce.Do(func() { close(a.lc.stop) })
+}
+
+// WithSignalSource makes Wait read signals from ch instead of subscribing
+// to the process signals, so lifecycle behaviour can be driven without sending
+// real signals to the process, e.g. in tests.
+func WithSignalSource(ch <-chan os.Signal) Option {
+	return optionFunc(func(a *Again) {
+		a.signalSource = ch
+	})
+}
+
+// ignoreSignals ignores the handled signals for the exec window: a signal
+// arriving then is neither delivered to a handler that is about to vanish nor
+// allowed to kill the process with its default action. Ignored dispositions
+// survive exec and the new image installs its own handlers. The returned
+// function undoes this if the exec failed.
+func (a *Again) ignoreSignals() (restore func()) {
+	if a.noSignals || a.signalSource != nil {
+		return func() {}
+	}
+	signal.Ignore(a.handledSignals()...)
+	return func() { muxSignals.restore(a.handledSignals()...) }
+}
+
+// WithSignalForwarding makes Wait forward the given signals to the child
+// while both generations are alive, so e.g. a SIGTERM sent by a supervisor
+// to the original PID reaches the generation actually serving. The signal is
+// still handled by the parent as well. SIGQUIT and SIGUSR2 are never
+// forwarded since they drive the upgrade itself.
+func WithSignalForwarding(sigs ...os.Signal) Option {
+	return optionFunc(func(a *Again) {
+		a.forward = append(a.forward, sigs...)
+	})
+}
+
+// forwardSignal sends sig to the child if it is configured for forwarding.
+func (a *Again) forwardSignal(sig os.Signal) {
+	if a.child.PID == 0 || sig == syscall.SIGQUIT || sig == SIGUSR2 {
+		return
+	}
+	for _, f := range a.forward {
+		if f != sig {
+			continue
+		}
+		if s, ok := sig.(syscall.Signal); ok {
+			if err := a.sys.Kill(a.child.PID, s); err != nil {
+				a.log(slog.LevelError, "forwarding signal", "signal", sig, "pid", a.child.PID, "err", err)
+			}
+		}
+		return
+	}
+}
@@ -0,0 +1,12 @@
+//go:build unix
+
+package again
+
+import "golang.org/x/sys/unix"
+
+const (
+	SIGUSR2 = unix.SIGUSR2
+
+	sigUSR1 = unix.SIGUSR1
+	sigCHLD = unix.SIGCHLD
+)
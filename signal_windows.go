@@ -0,0 +1,12 @@
+package again
+
+import "syscall"
+
+// Windows doesn't deliver these signals; they use the Linux numbers so
+// Trigger can still drive Wait.
+const (
+	SIGUSR2 = syscall.Signal(0xc)
+
+	sigUSR1 = syscall.Signal(0xa)
+	sigCHLD = syscall.Signal(0x11)
+)
@@ -0,0 +1,117 @@
+package again
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// SockOpt is an integer socket option set on a listener.
+type SockOpt struct {
+	Level int
+	Name  int
+	Value int
+}
+
+// WithControl registers fn to be called with the raw connection of every
+// listener inherited from the parent, after the recorded socket options were
+// reapplied. Use it for options that are not plain integers.
+func WithControl(fn func(s *Service, c syscall.RawConn) error) Option {
+	return optionFunc(func(a *Again) {
+		a.control = fn
+	})
+}
+
+// SetSockOpt sets an integer socket option on the listener of the named
+// service and records it, so the next generation sets it again after
+// inheriting the listener.
+func (a *Again) SetSockOpt(name string, level, opt, value int) error {
+	s := a.Get(name)
+	if s == nil {
+		return fmt.Errorf("%w: %q", ErrUnknownService, name)
+	}
+	o := SockOpt{Level: level, Name: opt, Value: value}
+	if err := setSockOpts(s.rawListener(), []SockOpt{o}); err != nil {
+		return err
+	}
+	a.lc.reg.Lock()
+	s.SockOpts = append(s.SockOpts, o)
+	a.lc.reg.Unlock()
+	return nil
+}
+
+// rawConn returns the syscall.RawConn of l, looking through the connection
+// tracking wrapper.
+func rawConn(l net.Listener) (syscall.RawConn, error) {
+	if t, ok := l.(*trackingListener); ok {
+		l = t.Listener
+	}
+	sc, ok := l.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T does not support socket options", ErrUnsupportedListener, l)
+	}
+	return sc.SyscallConn()
+}
+
+func setSockOpts(l net.Listener, opts []SockOpt) error {
+	if len(opts) == 0 {
+		return nil
+	}
+	rc, err := rawConn(l)
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		for _, o := range opts {
+			if serr = setsockoptInt(fd, o.Level, o.Name, o.Value); serr != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// encodeSockOpts encodes opts as "level.name.value" joined by "|".
+func encodeSockOpts(opts []SockOpt) string {
+	parts := make([]string, len(opts))
+	for i, o := range opts {
+		parts[i] = fmt.Sprintf("%d.%d.%d", o.Level, o.Name, o.Value)
+	}
+	return strings.Join(parts, "|")
+}
+
+func decodeSockOpts(v string) ([]SockOpt, error) {
+	if v == "" {
+		return nil, nil
+	}
+	var opts []SockOpt
+	for _, p := range strings.Split(v, "|") {
+		var o SockOpt
+		if _, err := fmt.Sscanf(p, "%d.%d.%d", &o.Level, &o.Name, &o.Value); err != nil {
+			return nil, fmt.Errorf("again: bad socket option %q: %v", p, err)
+		}
+		opts = append(opts, o)
+	}
+	return opts, nil
+}
+
+// restoreSockOpts reapplies the recorded options and runs the control
+// callback on an inherited service.
+func (a *Again) restoreSockOpts(s *Service) error {
+	if err := setSockOpts(s.rawListener(), s.SockOpts); err != nil {
+		return fmt.Errorf("again: service %s: %w", s.Name, err)
+	}
+	if a.control == nil {
+		return nil
+	}
+	rc, err := rawConn(s.rawListener())
+	if err != nil {
+		return err
+	}
+	return a.control(s, rc)
+}
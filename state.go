@@ -0,0 +1,216 @@
+package again
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// State is the lifecycle phase of an Again instance.
+type State int
+
+const (
+	// Starting is the state before Wait is called.
+	Starting State = iota
+	// Serving means listeners are accepting and no upgrade is running.
+	Serving
+	// Upgrading means a child process has been spawned and we are waiting
+	// for it to become ready.
+	Upgrading
+	// Draining means this process is shutting down gracefully, usually
+	// because the next generation took over.
+	Draining
+	// Stopped means Wait has returned.
+	Stopped
+)
+
+var stateNames = [...]string{
+	Starting:  "starting",
+	Serving:   "serving",
+	Upgrading: "upgrading",
+	Draining:  "draining",
+	Stopped:   "stopped",
+}
+
+func (s State) String() string {
+	if s >= 0 && int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return "unknown"
+}
+
+// EventType identifies the kind of an Event.
+type EventType int
+
+const (
+	// EventStateChanged is emitted on every state transition.
+	EventStateChanged EventType = iota
+	// EventParentExitTimeout is emitted when the parent exit timeout of a
+	// draining process passed. Conns lists the connections that were cut.
+	EventParentExitTimeout
+	// EventConnsReaped is emitted when connections are closed while
+	// draining, because they were idle or the drain deadline passed. Conns
+	// lists them.
+	EventConnsReaped
+	// EventWorkerStarted is emitted when a Pool starts a worker.
+	EventWorkerStarted
+	// EventWorkerExited is emitted when a Pool worker exits. Err is set
+	// unless it exited with status 0.
+	EventWorkerExited
+	// EventWorkerUpgraded is emitted when a rolling restart replaced the
+	// worker in a slot.
+	EventWorkerUpgraded
+	// EventUpgradeAborted is emitted when a rolling restart stops because a
+	// new worker failed, when a child calls AbortHandoff or fails the
+	// health check. Err says why.
+	EventUpgradeAborted
+	// EventUpgradeLocked is emitted when the upgrade lock was acquired.
+	// Duration is how long that took.
+	EventUpgradeLocked
+	// EventUpgradeUnlocked is emitted when the upgrade lock was released.
+	// Err is set if releasing it failed.
+	EventUpgradeUnlocked
+	// EventConnsExpired is emitted when connections are closed for
+	// exceeding their maximum age. Conns lists them.
+	EventConnsExpired
+	// EventWarmupProgress is emitted when a child reports the progress of
+	// its warmup, see WithWarmup.
+	EventWarmupProgress
+	// EventRestartScheduled is emitted when the next scheduled restart was
+	// planned. Duration is the time until it is due.
+	EventRestartScheduled
+	// EventWorkerRolledBack is emitted when a failed rolling restart put a
+	// worker of the previous binary back into a slot it had replaced.
+	EventWorkerRolledBack
+	// EventRollbackFailed is emitted when a slot replaced by a failed
+	// rolling restart couldn't be rolled back and keeps running the new
+	// binary. Err says why.
+	EventRollbackFailed
+)
+
+// Event is a notification about something that happened in an Again
+// instance.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// State is the current state, Prev the state before a transition.
+	State State
+	Prev  State
+	// Conns describes connections affected by the event as
+	// "service remote-address".
+	Conns []string
+	// PID is the process the event is about, Worker its Pool slot.
+	PID    int
+	Worker int
+	// Duration is the time the event is about, e.g. the lock wait.
+	Duration time.Duration
+	// Progress and Status are the warmup progress reported by a child.
+	Progress float64
+	Status   string
+	Err      error
+}
+
+// lifecycle holds the mutable state shared by all copies of an Again.
+type lifecycle struct {
+	mu       sync.Mutex
+	state    State
+	handlers []func(Event)
+	// trigger delivers signals passed to Trigger to Wait.
+	trigger chan os.Signal
+	// stop is closed by Stop.
+	stop     chan struct{}
+	stopOnce sync.Once
+	// after maps a service to the services closed before it.
+	after map[string][]string
+	// reg serializes service registration with upgrades.
+	reg sync.Mutex
+	// pool is the worker pool created with NewPool, if any.
+	pool *Pool
+	// partial lists the services of a pending UpgradeServices.
+	partial []string
+	// source is what triggered the upgrade started by Wait, if any.
+	source string
+	// history records upgrade attempts, see History.
+	history []UpgradeRecord
+	// locked is set while the upgrade lock is held.
+	locked bool
+	// stateOut produces the state handed to the next generation, stateIn
+	// is the state received from the parent.
+	stateOut map[string]func() ([]byte, error)
+	stateIn  map[string][]byte
+	// meta is the metadata set with SetHandoffMeta.
+	meta map[string]string
+	// connOut is the socket connections are handed to the child over,
+	// connIn the one they are received from the parent on.
+	connOut, connIn *net.UnixConn
+	// internal delivers signals raised by again itself to Wait, see
+	// deliver. handoff is the socket the handoff was received on.
+	internal chan sourcedSignal
+	handoff  *net.UnixConn
+	// env holds the handoff variables received over the handoff socket,
+	// see getenv.
+	env map[string]string
+	// reaping is held by the reaper while it reaps and by whoever starts a
+	// child it waits for itself until the child is listed in awaited.
+	reaping sync.Mutex
+	awaited map[int]bool
+	// unowned is set while the parent still serves the socket files of the
+	// inherited unix listeners, see claimSockets.
+	unowned bool
+	// drained is set while draining and closed by stopDrain. exitTimer is
+	// the parent exit deadline and timedOut records that it passed.
+	drained   chan struct{}
+	exitTimer *time.Timer
+	timedOut  bool
+	// trace holds the spans of the running upgrade, see WithTracer.
+	trace *upgradeTrace
+}
+
+// WithEventHandler registers fn to be called for every event. Handlers are
+// called synchronously and must not block.
+func WithEventHandler(fn func(Event)) Option {
+	return optionFunc(func(a *Again) {
+		a.lc.handlers = append(a.lc.handlers, fn)
+	})
+}
+
+// Subscribe registers fn to be called for every event emitted after the call.
+func (a *Again) Subscribe(fn func(Event)) {
+	a.lc.mu.Lock()
+	a.lc.handlers = append(a.lc.handlers, fn)
+	a.lc.mu.Unlock()
+}
+
+// State returns the current lifecycle state.
+func (a *Again) State() State {
+	a.lc.mu.Lock()
+	defer a.lc.mu.Unlock()
+	return a.lc.state
+}
+
+func (a *Again) setState(s State) {
+	a.lc.mu.Lock()
+	prev := a.lc.state
+	a.lc.state = s
+	a.lc.mu.Unlock()
+	if prev != s {
+		a.register(prev, s)
+		a.emit(Event{Type: EventStateChanged, State: s, Prev: prev})
+	}
+}
+
+func (a *Again) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Type != EventStateChanged {
+		e.State = a.State()
+	}
+	a.lc.mu.Lock()
+	handlers := append([]func(Event){}, a.lc.handlers...)
+	a.lc.mu.Unlock()
+	for _, fn := range handlers {
+		fn(e)
+	}
+}
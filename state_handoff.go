@@ -0,0 +1,99 @@
+package again
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// stateEnv names the descriptor the next generation reads handed over state
+// from.
+const stateEnv = "STATE_FD"
+
+// stateWriteTimeout bounds writing state into the pipe, which has to fit
+// into its buffer since the reader only starts after the upgrade.
+const stateWriteTimeout = time.Second
+
+// addState registers fn to produce the state stored under key for the next
+// generation.
+func (a *Again) addState(key string, fn func() ([]byte, error)) {
+	a.lc.mu.Lock()
+	defer a.lc.mu.Unlock()
+	if a.lc.stateOut == nil {
+		a.lc.stateOut = make(map[string]func() ([]byte, error))
+	}
+	a.lc.stateOut[key] = fn
+}
+
+// inheritedState returns the state the parent stored under key.
+func (a *Again) inheritedState(key string) ([]byte, bool) {
+	a.lc.mu.Lock()
+	defer a.lc.mu.Unlock()
+	b, ok := a.lc.stateIn[key]
+	return b, ok
+}
+
+// stateFile returns a pipe to read the state for the next generation from,
+// or nil if there is none.
+func (a *Again) stateFile() (*os.File, error) {
+	a.lc.mu.Lock()
+	keys := make([]string, 0, len(a.lc.stateOut))
+	for k := range a.lc.stateOut {
+		keys = append(keys, k)
+	}
+	fns := a.lc.stateOut
+	a.lc.mu.Unlock()
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	sort.Strings(keys)
+	state := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		b, err := fns[k]()
+		if err != nil {
+			return nil, fmt.Errorf("again: state %s: %w", k, err)
+		}
+		state[k] = b
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	w.SetWriteDeadline(time.Now().Add(stateWriteTimeout))
+	if _, err := w.Write(b); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("again: writing state: %w", err)
+	}
+	return r, nil
+}
+
+// readState reads the state handed over by the parent, if any.
+func (a *Again) readState() error {
+	var fd uintptr
+	if _, err := fmt.Sscan(a.getenv(stateEnv), &fd); err != nil {
+		return nil
+	}
+	a.unsetenv(stateEnv)
+	f := os.NewFile(fd, "state")
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("again: reading state: %w", err)
+	}
+	var state map[string][]byte
+	if err := json.Unmarshal(b, &state); err != nil {
+		return fmt.Errorf("again: reading state: %w", err)
+	}
+	a.lc.mu.Lock()
+	a.lc.stateIn = state
+	a.lc.mu.Unlock()
+	return nil
+}
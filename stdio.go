@@ -0,0 +1,64 @@
+package again
+
+import "os"
+
+// StdioFunc returns the stdin, stdout and stderr for the next generation. It
+// is called for every process started, so it can open fresh log files. Nil
+// files are replaced with /dev/null. Files other than os.Stdin, os.Stdout
+// and os.Stderr are closed in the parent once the child has been started.
+type StdioFunc func() (stdin, stdout, stderr *os.File, err error)
+
+// WithChildStdio sets the stdio policy for processes started by ForkExec or a
+// Pool. By default they share the stdio of the parent.
+func WithChildStdio(fn StdioFunc) Option {
+	return optionFunc(func(a *Again) {
+		a.childStdio = fn
+	})
+}
+
+// stdio returns the first three files for a child and a function closing the
+// ones opened for it.
+func (a *Again) stdio() ([]*os.File, func(), error) {
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	if a.childStdio == nil && !a.detach {
+		return files, func() {}, nil
+	}
+	if a.childStdio != nil {
+		in, out, errf, err := a.childStdio()
+		if err != nil {
+			return nil, nil, err
+		}
+		files = []*os.File{in, out, errf}
+	} else {
+		// A detached child must not hold on to our terminal.
+		for i, f := range files {
+			if isTerminal(f) {
+				files[i] = nil
+			}
+		}
+	}
+	var devNull *os.File
+	var err error
+	for i, f := range files {
+		if f != nil {
+			continue
+		}
+		if devNull == nil {
+			if devNull, err = os.OpenFile(os.DevNull, os.O_RDWR, 0); err != nil {
+				return nil, nil, err
+			}
+		}
+		files[i] = devNull
+	}
+	opened := append([]*os.File(nil), files...)
+	return files, func() {
+		seen := make(map[*os.File]bool)
+		for _, f := range opened {
+			if f == os.Stdin || f == os.Stdout || f == os.Stderr || seen[f] {
+				continue
+			}
+			seen[f] = true
+			f.Close()
+		}
+	}, nil
+}
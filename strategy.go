@@ -0,0 +1,101 @@
+package again
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// UpgradeStrategy carries out the upgrades Wait starts on SIGUSR2, so new
+// mechanisms can be added without touching Wait.
+type UpgradeStrategy interface {
+	// Upgrade is called on SIGUSR2 after the fork hook. pending is set if an
+	// earlier call started an upgrade that hasn't finished yet. If exit is
+	// true Wait returns err; otherwise a non-nil err aborts the upgrade and
+	// Wait keeps serving.
+	Upgrade(a *Again, pending bool) (exit bool, err error)
+}
+
+// Strategy is one of the built-in upgrade strategies. The first two follow
+// goagain, so runbooks written for it keep working.
+type Strategy int
+
+const (
+	// StrategySingle forks a child that inherits the listeners; the child
+	// signals the parent with SIGQUIT to exit once it is serving. Another
+	// SIGUSR2 before that makes Wait return.
+	StrategySingle Strategy = iota
+	// StrategyDouble forks a child that inherits the listeners; the child
+	// signals the parent with SIGUSR2, the parent execs itself and the new
+	// image signals the child with SIGQUIT to exit. The service ends up in
+	// the original PID.
+	StrategyDouble
+	// StrategyExec replaces the process image with Exec, see
+	// WithExecUpgrades.
+	StrategyExec
+	// StrategyReusePort forks a child that binds the addresses of all
+	// services afresh with SO_REUSEPORT instead of inheriting descriptors.
+	// The listeners of this process must have SO_REUSEPORT set as well.
+	StrategyReusePort
+)
+
+// Upgrade implements UpgradeStrategy.
+func (s Strategy) Upgrade(a *Again, pending bool) (bool, error) {
+	switch {
+	case s == StrategyExec:
+		return false, Exec(a)
+	case pending && s == StrategyDouble:
+		a.upgraded()
+		return true, execDouble(a)
+	case pending:
+		return true, nil
+	case s == StrategyReusePort:
+		err := forkExec(a, nil, map[string]string{a.envName(reusePortEnv): "1"})
+		return err != nil, err
+	}
+	err := ForkExec(a)
+	return err != nil, err
+}
+
+// External is an UpgradeStrategy for upgrades carried out by a supervisor:
+// the function asks it to start the next generation, which stops this
+// process with SIGQUIT once it is serving.
+type External func(a *Again) error
+
+// Upgrade implements UpgradeStrategy.
+func (f External) Upgrade(a *Again, pending bool) (bool, error) {
+	if pending {
+		return false, nil
+	}
+	return false, f(a)
+}
+
+// WithStrategy sets the upgrade strategy. The default is StrategySingle.
+func WithStrategy(s UpgradeStrategy) Option {
+	return optionFunc(func(a *Again) {
+		a.strategy = s
+	})
+}
+
+// upgradeStrategy returns the configured strategy.
+func (a *Again) upgradeStrategy() UpgradeStrategy {
+	if a.strategy == nil {
+		return StrategySingle
+	}
+	return a.strategy
+}
+
+// execDouble is the second exec of StrategyDouble: the new image takes the
+// listeners back and stops the child, whose PID is in GOAGAIN_PID.
+func execDouble(a *Again) error {
+	if a.child.PID == 0 {
+		return fmt.Errorf("again: double exec without a child")
+	}
+	return execImage(a, map[string]string{
+		a.envName("PID"):        fmt.Sprint(a.child.PID),
+		a.envName("PID_START"):  startEnv(a.child.PID),
+		a.envName("PPID"):       "",
+		a.envName("PPID_START"): "",
+		a.envName("SIGNAL"):     fmt.Sprint(int(syscall.SIGQUIT)),
+		a.envName(execEnv):      "",
+	})
+}
@@ -0,0 +1,42 @@
+package again
+
+import (
+	"os"
+	"syscall"
+)
+
+// OS is the set of operating system calls used to hand listeners to the next
+// generation. Replace it with WithOS to exercise the upgrade logic without
+// forking.
+type OS interface {
+	// Exec replaces the current process image.
+	Exec(argv0 string, argv, envv []string) error
+	// StartProcess starts a new process and returns its PID.
+	StartProcess(argv0 string, argv []string, attr *os.ProcAttr) (int, error)
+	// Kill sends sig to pid.
+	Kill(pid int, sig syscall.Signal) error
+	// SetCloexec sets or clears FD_CLOEXEC on fd.
+	SetCloexec(fd uintptr, cloexec bool) error
+	// DupCloexec duplicates fd with FD_CLOEXEC set.
+	DupCloexec(fd uintptr) (uintptr, error)
+}
+
+// WithOS replaces the operating system calls used by the instance.
+func WithOS(o OS) Option {
+	return optionFunc(func(a *Again) {
+		a.sys = o
+	})
+}
+
+// sysOS implements OS with real system calls.
+type sysOS struct{}
+
+func (sysOS) StartProcess(argv0 string, argv []string, attr *os.ProcAttr) (int, error) {
+	p, err := os.StartProcess(argv0, argv, attr)
+	if err != nil {
+		return 0, err
+	}
+	pid := p.Pid
+	p.Release()
+	return pid, nil
+}
@@ -0,0 +1,50 @@
+//go:build unix
+
+package again
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func (sysOS) Exec(argv0 string, argv, envv []string) error {
+	return unix.Exec(argv0, argv, envv)
+}
+
+func (sysOS) Kill(pid int, sig syscall.Signal) error {
+	return unix.Kill(pid, sig)
+}
+
+func (sysOS) SetCloexec(fd uintptr, cloexec bool) error {
+	flag := 0
+	if cloexec {
+		flag = unix.FD_CLOEXEC
+	}
+	_, err := unix.FcntlInt(fd, unix.F_SETFD, flag)
+	return err
+}
+
+func (sysOS) DupCloexec(fd uintptr) (uintptr, error) {
+	return dupCloexec(fd)
+}
+
+func setsockoptInt(fd uintptr, level, opt, value int) error {
+	return unix.SetsockoptInt(int(fd), level, opt, value)
+}
+
+// waitPid waits for the child pid to exit.
+func waitPid(pid int) (syscall.WaitStatus, error) {
+	var ws unix.WaitStatus
+	for {
+		_, err := unix.Wait4(pid, &ws, 0, nil)
+		if err != unix.EINTR {
+			return syscall.WaitStatus(ws), err
+		}
+	}
+}
+
+// processGroup returns the process group of pid.
+func processGroup(pid int) (int, error) {
+	return unix.Getpgid(pid)
+}
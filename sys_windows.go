@@ -0,0 +1,64 @@
+package again
+
+import (
+	"os"
+	"syscall"
+)
+
+// Windows can't rebuild a net.Listener from an inherited handle, or one
+// duplicated with WSADuplicateSocket, so the calls handing listeners to
+// another process fail with EWINDOWS. Services, hooks, Trigger, draining and
+// signals sent over WithControlPipe work as elsewhere.
+
+func (sysOS) Exec(argv0 string, argv, envv []string) error {
+	return syscall.EWINDOWS
+}
+
+// Kill can only check for existence with signal 0 and terminate a process;
+// other signals fail.
+func (sysOS) Kill(pid int, sig syscall.Signal) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return syscall.ESRCH
+	}
+	defer p.Release()
+	if sig == 0 {
+		return nil
+	}
+	return p.Signal(sig)
+}
+
+func (sysOS) SetCloexec(fd uintptr, cloexec bool) error {
+	return syscall.EWINDOWS
+}
+
+func (sysOS) DupCloexec(fd uintptr) (uintptr, error) {
+	return 0, syscall.EWINDOWS
+}
+
+func setsockoptInt(fd uintptr, level, opt, value int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), level, opt, value)
+}
+
+// waitPid waits for the child pid to exit.
+func waitPid(pid int) (syscall.WaitStatus, error) {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return syscall.WaitStatus{}, err
+	}
+	st, err := p.Wait()
+	if err != nil {
+		return syscall.WaitStatus{}, err
+	}
+	return st.Sys().(syscall.WaitStatus), nil
+}
+
+// setReusePort fails, Windows has no SO_REUSEPORT.
+func setReusePort(fd uintptr) error {
+	return syscall.EWINDOWS
+}
+
+// processGroup is not supported on Windows.
+func processGroup(pid int) (int, error) {
+	return 0, syscall.EWINDOWS
+}
@@ -0,0 +1,97 @@
+package again
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// ErrTargetChanged is matched by a TargetError.
+var ErrTargetChanged = errors.New("again: kill target changed")
+
+// TargetError is returned by Kill and KillWithTimeout when the process in
+// the environment is no longer the one that started this process, e.g.
+// because it exited and its PID was reused.
+type TargetError struct {
+	PID    int
+	Reason string
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("again: kill target %d: %s", e.PID, e.Reason)
+}
+
+// Is makes TargetError match ErrTargetChanged.
+func (e *TargetError) Is(target error) bool {
+	return target == ErrTargetChanged
+}
+
+// WithKillProcessGroup makes Kill and KillWithTimeout signal the process
+// group of the target instead of just the target, so helpers the old
+// generation started in its group stop with it. The target must lead a
+// group this process is not in, e.g. one started with Setpgid through
+// WithProcAttr; Kill fails with a TargetError otherwise.
+func WithKillProcessGroup() Option {
+	return optionFunc(func(a *Again) {
+		a.killGroup = true
+	})
+}
+
+// startEnv returns the start time of pid as recorded in the PID_START and
+// PPID_START variables, or "" if it can't be told.
+func startEnv(pid int) string {
+	t, err := procStart(pid)
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatUint(t, 10)
+}
+
+// verifyTarget checks that pid, taken from the variable name of the
+// environment, is still the process that was recorded there. The start
+// time is compared where the platform can tell it; otherwise a parent is
+// expected to still be our parent.
+func verifyTarget(a *Again, pid int, name string) error {
+	if want := a.getenv(name + "_START"); want != "" {
+		if got := startEnv(pid); got != "" {
+			if got != want {
+				return &TargetError{PID: pid, Reason: "process started at " + got + ", expected " + want}
+			}
+			return nil
+		}
+	}
+	if name == "PPID" && os.Getppid() != pid {
+		return &TargetError{PID: pid, Reason: "not our parent anymore"}
+	}
+	return nil
+}
+
+// killPid returns what notify signals for pid: pid itself or, with
+// WithKillProcessGroup, its process group.
+func (a *Again) killPid(pid int) (int, error) {
+	if a == nil || !a.killGroup {
+		return pid, nil
+	}
+	pgid, err := processGroup(pid)
+	if err != nil {
+		return 0, fmt.Errorf("again: process group of %d: %w", pid, err)
+	}
+	own, err := processGroup(os.Getpid())
+	if err != nil {
+		return 0, fmt.Errorf("again: process group: %w", err)
+	}
+	if pgid == own {
+		return 0, &TargetError{PID: pid, Reason: "shares our process group"}
+	}
+	if pgid != pid {
+		return 0, &TargetError{PID: pid, Reason: "does not lead its process group"}
+	}
+	return -pgid, nil
+}
+
+// alive reports whether pid still exists.
+func alive(sys OS, pid int) bool {
+	return sys.Kill(pid, 0) != syscall.ESRCH
+}
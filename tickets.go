@@ -0,0 +1,123 @@
+package again
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ticketKeyCount is the number of session ticket keys kept: the current one
+// and the ones before it, so tickets issued shortly before a rotation still
+// resume.
+const ticketKeyCount = 3
+
+// ticketState is the key material handed to the next generation.
+type ticketState struct {
+	Keys    [][32]byte `json:"keys"`
+	Rotated time.Time  `json:"rotated"`
+}
+
+// ticketKeys manages the session ticket keys of a tls.Config.
+type ticketKeys struct {
+	cfg    *tls.Config
+	rotate time.Duration
+
+	mu    sync.Mutex
+	state ticketState
+}
+
+// WithSessionTickets makes cfg use session ticket keys that are handed to
+// the next generation, so resumed TLS sessions keep working across upgrades
+// instead of every client doing a full handshake. A new key is added every
+// rotate while Wait runs, or never if rotate is 0; the schedule carries on
+// from where the parent left off. The keys travel through a pipe, not the
+// environment.
+func WithSessionTickets(cfg *tls.Config, rotate time.Duration) Option {
+	return optionFunc(func(a *Again) {
+		t := &ticketKeys{cfg: cfg, rotate: rotate}
+		if err := t.add(time.Now()); err != nil {
+			a.log(slog.LevelError, "again: session ticket key", "err", err)
+		}
+		a.tickets = t
+		a.addState("tls-tickets", func() ([]byte, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			return json.Marshal(t.state)
+		})
+	})
+}
+
+// add makes a new key the current one.
+func (t *ticketKeys) add(now time.Time) error {
+	var k [32]byte
+	if _, err := rand.Read(k[:]); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.Keys = append([][32]byte{k}, t.state.Keys...)
+	if len(t.state.Keys) > ticketKeyCount {
+		t.state.Keys = t.state.Keys[:ticketKeyCount]
+	}
+	t.state.Rotated = now
+	t.cfg.SetSessionTicketKeys(t.state.Keys)
+	return nil
+}
+
+// restore takes over the keys of the parent.
+func (t *ticketKeys) restore(b []byte) error {
+	var st ticketState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return err
+	}
+	if len(st.Keys) == 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state = st
+	t.cfg.SetSessionTicketKeys(st.Keys)
+	return nil
+}
+
+// restoreTickets applies the session ticket keys inherited from the parent.
+func (a *Again) restoreTickets() error {
+	if a.tickets == nil {
+		return nil
+	}
+	if b, ok := a.inheritedState("tls-tickets"); ok {
+		return a.tickets.restore(b)
+	}
+	return nil
+}
+
+// startTicketRotation rotates the session ticket keys until the returned
+// function is called.
+func (a *Again) startTicketRotation() func() {
+	t := a.tickets
+	if t == nil || t.rotate <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			t.mu.Lock()
+			next := time.Until(t.state.Rotated.Add(t.rotate))
+			t.mu.Unlock()
+			timer := time.NewTimer(next)
+			select {
+			case now := <-timer.C:
+				if err := t.add(now); err != nil {
+					a.log(slog.LevelError, "again: session ticket key", "err", err)
+				}
+			case <-done:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,128 @@
+package again
+
+import (
+	"context"
+	"os"
+)
+
+// Attr is an attribute of a span.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// Tracer starts spans, e.g. OpenTelemetry spans through an adapter, which
+// keeps this package free of the dependency:
+//
+//	type otelTracer struct{ t trace.Tracer }
+//
+//	func (o otelTracer) Start(ctx context.Context, name string, attrs ...again.Attr) (context.Context, again.Span) {
+//		ctx, s := o.t.Start(ctx, name)
+//		sp := otelSpan{s}
+//		sp.SetAttributes(attrs...)
+//		return ctx, sp
+//	}
+//
+// where otelSpan converts each Attr with attribute.String, attribute.Int
+// and so on, and End records a non-nil error and sets the status before
+// ending the span.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attr) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttributes(attrs ...Attr)
+	// End ends the span, which failed if err is not nil.
+	End(err error)
+}
+
+// WithTracer traces upgrades with t. An upgrade is an "again.upgrade" span
+// with children "again.fork" for starting the child, "again.ready" for
+// waiting until it reports ready, "again.drain" for the OnSIGQUIT hooks
+// and "again.exit" for the rest of the exit. Spans carry the PID and
+// generation of the process they are about.
+func WithTracer(t Tracer) Option {
+	return optionFunc(func(a *Again) {
+		a.tracer = t
+	})
+}
+
+// upgradeTrace holds the spans of the running upgrade.
+type upgradeTrace struct {
+	ctx   context.Context
+	root  Span
+	ready Span
+}
+
+// traceUpgrade starts the span of an upgrade started by source.
+func (a *Again) traceUpgrade(source string) {
+	if a.tracer == nil {
+		return
+	}
+	ctx, root := a.tracer.Start(context.Background(), "again.upgrade",
+		Attr{"again.pid", os.Getpid()},
+		Attr{"again.generation", a.generation},
+		Attr{"again.trigger", source})
+	a.lc.mu.Lock()
+	a.lc.trace = &upgradeTrace{ctx: ctx, root: root}
+	a.lc.mu.Unlock()
+}
+
+// span starts a child span of the running upgrade and returns the function
+// ending it. Without an upgrade it is a root span.
+func (a *Again) span(name string, attrs ...Attr) func(error) {
+	if a.tracer == nil {
+		return func(error) {}
+	}
+	ctx := context.Background()
+	a.lc.mu.Lock()
+	if a.lc.trace != nil {
+		ctx = a.lc.trace.ctx
+	}
+	a.lc.mu.Unlock()
+	_, s := a.tracer.Start(ctx, name, attrs...)
+	return s.End
+}
+
+// traceReady starts waiting for child to report ready.
+func (a *Again) traceReady(child ChildInfo) {
+	if a.tracer == nil {
+		return
+	}
+	a.lc.mu.Lock()
+	defer a.lc.mu.Unlock()
+	t := a.lc.trace
+	if t == nil {
+		return
+	}
+	t.root.SetAttributes(Attr{"again.child.pid", child.PID}, Attr{"again.child.generation", child.Generation})
+	_, t.ready = a.tracer.Start(t.ctx, "again.ready",
+		Attr{"again.child.pid", child.PID},
+		Attr{"again.child.generation", child.Generation})
+}
+
+// tracedReady ends waiting for the child, which failed if err is set.
+func (a *Again) tracedReady(err error) {
+	a.lc.mu.Lock()
+	defer a.lc.mu.Unlock()
+	if t := a.lc.trace; t != nil && t.ready != nil {
+		t.ready.End(err)
+		t.ready = nil
+	}
+}
+
+// endTrace ends the span of the running upgrade.
+func (a *Again) endTrace(err error) {
+	a.lc.mu.Lock()
+	t := a.lc.trace
+	a.lc.trace = nil
+	a.lc.mu.Unlock()
+	if t == nil {
+		return
+	}
+	if t.ready != nil {
+		t.ready.End(err)
+	}
+	t.root.End(err)
+}
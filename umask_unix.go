@@ -0,0 +1,9 @@
+//go:build unix
+
+package again
+
+import "syscall"
+
+func setUmask(mask int) {
+	syscall.Umask(mask)
+}
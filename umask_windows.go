@@ -0,0 +1,4 @@
+package again
+
+// setUmask does nothing, Windows has no umask.
+func setUmask(mask int) {}
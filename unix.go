@@ -0,0 +1,111 @@
+package again
+
+import (
+	"net"
+	"os"
+	"time"
+)
+
+// parentPollInterval is how often a child checks whether its parent, which
+// still serves the inherited socket files, exited.
+const parentPollInterval = 100 * time.Millisecond
+
+// WithUnixSocketPerms sets the mode and owner of socket files of unix
+// listeners registered with Listen. A uid or gid of -1 leaves it unchanged.
+// Inherited listeners are not touched since the file already exists.
+func WithUnixSocketPerms(mode os.FileMode, uid, gid int) Option {
+	return optionFunc(func(a *Again) {
+		a.unixPerms = &unixPerms{mode: mode, uid: uid, gid: gid}
+	})
+}
+
+type unixPerms struct {
+	mode     os.FileMode
+	uid, gid int
+}
+
+// unixListener returns the *net.UnixListener of s or nil.
+func (s *Service) unixListener() *net.UnixListener {
+	u, _ := s.rawListener().(*net.UnixListener)
+	return u
+}
+
+// socketPath returns the file system path of a unix listener or "" for other
+// listeners and abstract sockets.
+func socketPath(u *net.UnixListener) string {
+	name := u.Addr().String()
+	if name == "" || name[0] == '@' {
+		return ""
+	}
+	return name
+}
+
+// applyUnixPerms applies the configured permissions to the socket file of s.
+func (a *Again) applyUnixPerms(s *Service) error {
+	u := s.unixListener()
+	if u == nil || a.unixPerms == nil {
+		return nil
+	}
+	path := socketPath(u)
+	if path == "" {
+		return nil
+	}
+	if err := os.Chmod(path, a.unixPerms.mode); err != nil {
+		return err
+	}
+	if a.unixPerms.uid != -1 || a.unixPerms.gid != -1 {
+		return os.Chown(path, a.unixPerms.uid, a.unixPerms.gid)
+	}
+	return nil
+}
+
+// setUnlinkOnClose controls whether closing unix listeners removes their
+// socket files. It is disabled once the listeners were handed to the next
+// generation, which keeps using the same files.
+func (a *Again) setUnlinkOnClose(unlink bool) {
+	a.Range(func(s *Service) {
+		if u := s.unixListener(); u != nil {
+			u.SetUnlinkOnClose(unlink)
+		}
+	})
+}
+
+// claimSockets makes closing the inherited unix listeners remove their
+// socket files once the parent is gone. Until then the parent may still
+// reject this process, e.g. when it fails the health check, and carry on
+// serving on the same files, so they must outlive us.
+func (a *Again) claimSockets() {
+	if a.ppid == 0 {
+		a.setUnlinkOnClose(true)
+		return
+	}
+	a.lc.mu.Lock()
+	a.lc.unowned = true
+	a.lc.mu.Unlock()
+	forked := os.Getppid() == a.ppid
+	go func() {
+		for alive(a.sys, a.ppid) && (!forked || os.Getppid() == a.ppid) {
+			select {
+			case <-a.lc.stop:
+				return
+			case <-time.After(parentPollInterval):
+			}
+		}
+		a.lc.mu.Lock()
+		a.lc.unowned = false
+		a.lc.mu.Unlock()
+		// Files handed on to a generation of our own are not ours to
+		// remove either; a failed upgrade restores the flag in rollback.
+		if s := a.State(); s != Upgrading && s != Draining && s != Stopped {
+			a.setUnlinkOnClose(true)
+		}
+	}()
+}
+
+// ownsSockets reports whether the socket files of the inherited unix
+// listeners are ours to remove, see claimSockets.
+func (a *Again) ownsSockets() bool {
+	a.lc.mu.Lock()
+	defer a.lc.mu.Unlock()
+	return !a.lc.unowned
+}
@@ -0,0 +1,25 @@
+package again
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FdError reports an inherited descriptor that doesn't match what the parent
+// registered for a service.
+type FdError struct {
+	Service string
+	Fd      uintptr
+	Reason  string
+}
+
+func (e *FdError) Error() string {
+	return fmt.Sprintf("again: service %s: fd %d: %s", e.Service, e.Fd, e.Reason)
+}
+
+func stripZone(host string) string {
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		return host[:i]
+	}
+	return host
+}
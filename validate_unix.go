@@ -0,0 +1,89 @@
+//go:build unix
+
+package again
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// validateFd checks that the descriptor of s is a listening or datagram
+// socket of the family and type recorded in its FdName and bound to the
+// recorded address.
+func validateFd(s *Service) error {
+	fd := int(s.Descriptor)
+	fail := func(format string, args ...interface{}) error {
+		return &FdError{Service: s.Name, Fd: s.Descriptor, Reason: fmt.Sprintf(format, args...)}
+	}
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return fail("%v", err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFSOCK {
+		return fail("not a socket")
+	}
+	network, addr := parseFdName(s.FdName)
+	// Datagram and raw sockets don't listen.
+	if !isPacketNetwork(network) && network != "raw" {
+		if v, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ACCEPTCONN); err == nil && v == 0 {
+			return fail("socket is not listening")
+		}
+	}
+	typ, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TYPE)
+	if err != nil {
+		return fail("%v", err)
+	}
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return fail("%v", err)
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+		want, wantName := unix.SOCK_STREAM, "SOCK_STREAM"
+		if isPacketNetwork(network) {
+			want, wantName = unix.SOCK_DGRAM, "SOCK_DGRAM"
+		}
+		if typ != want {
+			return fail("socket type %d, want %s", typ, wantName)
+		}
+		var ip net.IP
+		var port int
+		switch sa := sa.(type) {
+		case *unix.SockaddrInet4:
+			ip, port = net.IP(sa.Addr[:]), sa.Port
+		case *unix.SockaddrInet6:
+			ip, port = net.IP(sa.Addr[:]), sa.Port
+		default:
+			return fail("address family %T, want inet", sa)
+		}
+		host, p, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fail("bad recorded address %q", addr)
+		}
+		if want := net.ParseIP(stripZone(host)); !want.Equal(ip) || p != strconv.Itoa(port) {
+			return fail("bound to %s, want %s", net.JoinHostPort(ip.String(), strconv.Itoa(port)), addr)
+		}
+	case "unix", "unixpacket", "unixgram":
+		want := unix.SOCK_STREAM
+		switch network {
+		case "unixpacket":
+			want = unix.SOCK_SEQPACKET
+		case "unixgram":
+			want = unix.SOCK_DGRAM
+		}
+		if typ != want {
+			return fail("socket type %d, want %d", typ, want)
+		}
+		u, ok := sa.(*unix.SockaddrUnix)
+		if !ok {
+			return fail("address family %T, want unix", sa)
+		}
+		if u.Name != addr {
+			return fail("bound to %q, want %q", u.Name, addr)
+		}
+	}
+	return nil
+}
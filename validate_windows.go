@@ -0,0 +1,7 @@
+package again
+
+// validateFd accepts any handle; rebuilding the listener fails on Windows
+// anyway.
+func validateFd(s *Service) error {
+	return nil
+}
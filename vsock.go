@@ -0,0 +1,14 @@
+package again
+
+import "fmt"
+
+// VsockAddr is the address of an AF_VSOCK socket, used between virtual
+// machines and their host, e.g. by firecracker and cloud-hypervisor agents.
+type VsockAddr struct {
+	CID  uint32
+	Port uint32
+}
+
+func (a *VsockAddr) Network() string { return "vsock" }
+
+func (a *VsockAddr) String() string { return fmt.Sprintf("%d:%d", a.CID, a.Port) }
@@ -0,0 +1,128 @@
+package again
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ListenVsock listens on port for connections from any context ID. The
+// listener implements FdListener and is rebuilt by ListenFrom without a
+// factory.
+func ListenVsock(port uint32) (net.Listener, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	sa := &unix.SockaddrVM{CID: unix.VMADDR_CID_ANY, Port: port}
+	if err := unix.Bind(fd, sa); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("listen", err)
+	}
+	return newVsockListener(fd)
+}
+
+// vsockListener accepts vsock connections through the runtime poller.
+type vsockListener struct {
+	f    *os.File
+	rc   syscall.RawConn
+	addr *VsockAddr
+}
+
+// newVsockListener takes over the non-blocking listening socket fd.
+func newVsockListener(fd int) (*vsockListener, error) {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+	f := os.NewFile(uintptr(fd), "vsock")
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &vsockListener{f: f, rc: rc, addr: vsockAddr(sa)}, nil
+}
+
+// vsockFactory rebuilds an inherited vsock listener.
+func vsockFactory(f *os.File) (FdListener, error) {
+	fd, err := unix.FcntlInt(f.Fd(), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("fcntl", err)
+	}
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("setnonblock", err)
+	}
+	return newVsockListener(fd)
+}
+
+func (l *vsockListener) Accept() (net.Conn, error) {
+	var nfd int
+	var sa unix.Sockaddr
+	var aerr error
+	err := l.rc.Read(func(fd uintptr) bool {
+		nfd, sa, aerr = unix.Accept4(int(fd), unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
+		return aerr != unix.EAGAIN
+	})
+	if err == nil {
+		err = aerr
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrClosed) {
+			err = net.ErrClosed
+		}
+		return nil, &net.OpError{Op: "accept", Net: "vsock", Addr: l.addr, Err: err}
+	}
+	local, err := unix.Getsockname(nfd)
+	if err != nil {
+		unix.Close(nfd)
+		return nil, &net.OpError{Op: "accept", Net: "vsock", Addr: l.addr, Err: err}
+	}
+	return &vsockConn{
+		File:   os.NewFile(uintptr(nfd), "vsock"),
+		local:  vsockAddr(local),
+		remote: vsockAddr(sa),
+	}, nil
+}
+
+func (l *vsockListener) Close() error { return l.f.Close() }
+
+func (l *vsockListener) Addr() net.Addr { return l.addr }
+
+func (l *vsockListener) Fd() (uintptr, error) {
+	var fd uintptr
+	err := l.rc.Control(func(f uintptr) { fd = f })
+	return fd, err
+}
+
+// vsockConn is an accepted vsock connection.
+type vsockConn struct {
+	*os.File
+	local, remote *VsockAddr
+}
+
+func (c *vsockConn) LocalAddr() net.Addr  { return c.local }
+func (c *vsockConn) RemoteAddr() net.Addr { return c.remote }
+
+func vsockAddr(sa unix.Sockaddr) *VsockAddr {
+	if vm, ok := sa.(*unix.SockaddrVM); ok {
+		return &VsockAddr{CID: vm.CID, Port: vm.Port}
+	}
+	return &VsockAddr{}
+}
+
+func builtinCodec(network string) Codec {
+	if network == "vsock" {
+		return factoryCodec(vsockFactory)
+	}
+	return nil
+}
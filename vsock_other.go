@@ -0,0 +1,17 @@
+//go:build !linux
+
+package again
+
+import (
+	"net"
+	"syscall"
+)
+
+// ListenVsock fails, vsock is only supported on Linux.
+func ListenVsock(port uint32) (net.Listener, error) {
+	return nil, syscall.EAFNOSUPPORT
+}
+
+func builtinCodec(network string) Codec {
+	return nil
+}
@@ -0,0 +1,58 @@
+package again_test
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/TykTechnologies/again"
+)
+
+func TestWaitSignalSource(t *testing.T) {
+	ch := make(chan os.Signal, 2)
+	a := again.New(again.WithSignalSource(ch))
+	var hup, term int
+	a.Hooks.OnSIGHUP = func(*again.Again) error { hup++; return nil }
+	a.Hooks.OnSIGTERM = func(*again.Again) error { term++; return nil }
+	ch <- syscall.SIGHUP
+	ch <- syscall.SIGTERM
+	r := again.WaitResult(&a)
+	if r.Signal != syscall.SIGTERM || r.Source != "signal" || r.Err != nil {
+		t.Fatalf("Wait returned %v from %q: %v", r.Signal, r.Source, r.Err)
+	}
+	if hup != 1 || term != 1 {
+		t.Errorf("ran OnSIGHUP %d and OnSIGTERM %d times, want once each", hup, term)
+	}
+	if s := a.State(); s != again.Stopped {
+		t.Errorf("state %v, want %v", s, again.Stopped)
+	}
+}
+
+func TestWaitTrigger(t *testing.T) {
+	a := again.New(again.WithSignalSource(make(chan os.Signal)))
+	go a.Trigger(syscall.SIGINT)
+	r := again.WaitResult(&a)
+	if r.Signal != syscall.SIGINT || r.Source != "trigger" {
+		t.Fatalf("Wait returned %v from %q, want SIGINT from trigger", r.Signal, r.Source)
+	}
+}
+
+func TestWaitStop(t *testing.T) {
+	a := again.New(again.WithSignalSource(make(chan os.Signal)))
+	a.Stop()
+	if _, err := again.Wait(&a); err != again.ErrStopped {
+		t.Fatalf("Wait after Stop: %v, want %v", err, again.ErrStopped)
+	}
+}
+
+func TestWaitResultHookError(t *testing.T) {
+	ch := make(chan os.Signal, 1)
+	a := again.New(again.WithSignalSource(ch))
+	hookErr := errors.New("hook failed")
+	a.Hooks.OnSIGTERM = func(*again.Again) error { return hookErr }
+	ch <- syscall.SIGTERM
+	if r := again.WaitResult(&a); !errors.Is(r.Err, hookErr) {
+		t.Fatalf("Wait returned %v, want %v", r.Err, hookErr)
+	}
+}
@@ -0,0 +1,54 @@
+package again
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Warmup prepares a new generation to serve, e.g. by priming caches or
+// filling connection pools. It calls report with how far it got, from 0 to
+// 1, and what it is doing.
+type Warmup func(ctx context.Context, report func(progress float64, status string)) error
+
+// WithWarmup makes ListenFrom and Adopt run fn once the listeners have been
+// inherited, before returning, so the child only reports ready with Kill
+// after it is warm while the parent keeps accepting. With
+// WithSocketHandoff or over the control socket the progress is sent to the
+// parent, which emits EventWarmupProgress for it. If fn fails or doesn't
+// finish within timeout, 0 meaning no limit, the handoff is aborted, see
+// AbortHandoff, and ListenFrom returns the error.
+func WithWarmup(fn Warmup, timeout time.Duration) Option {
+	return optionFunc(func(a *Again) {
+		a.warmupFn = fn
+		a.warmupTimeout = timeout
+	})
+}
+
+// warmup runs the warmup routine, if any.
+func (a *Again) warmup() error {
+	if a.warmupFn == nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if a.warmupTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), a.warmupTimeout)
+	}
+	defer cancel()
+	report := func(progress float64, status string) {
+		err := a.answer(handoffAck{Warmup: true, Progress: progress, Status: status})
+		if err != nil && err != ErrNoHandoffSocket {
+			a.log(slog.LevelError, "again: warmup", "err", err)
+		}
+	}
+	err := a.warmupFn(ctx, report)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		err = fmt.Errorf("again: warmup: %w", err)
+		a.abortHandoff(err)
+	}
+	return err
+}
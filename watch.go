@@ -0,0 +1,94 @@
+package again
+
+import (
+	"log/slog"
+	"os"
+	"time"
+)
+
+// watchInterval is how often WithUpgradeWatch looks at the watched file.
+const watchInterval = time.Second
+
+// WithUpgradeWatch makes Wait start an upgrade, as if it got SIGUSR2, when
+// the file at path changes, so copying a new binary into place is all a
+// deploy has to do. If path is "" the executable is watched and only a
+// change of its checksum counts; any other file is a trigger file, which
+// counts whenever it is modified, e.g. touched. The file has to stay
+// unchanged for debounce before the upgrade starts, so a binary still being
+// copied isn't run. Changes are polled for, without fsnotify.
+func WithUpgradeWatch(path string, debounce time.Duration) Option {
+	return optionFunc(func(a *Again) {
+		a.watch = &upgradeWatch{path: path, debounce: debounce}
+	})
+}
+
+// upgradeWatch is the configuration of WithUpgradeWatch.
+type upgradeWatch struct {
+	path     string
+	debounce time.Duration
+}
+
+// startWatch watches for upgrades, if configured, until the returned
+// function is called.
+func (a *Again) startWatch() func() {
+	if a.watch == nil {
+		return func() {}
+	}
+	path, binary := a.watch.path, a.watch.path == ""
+	if binary {
+		var err error
+		if path, err = a.lookPath(); err != nil {
+			a.log(slog.LevelError, "again: upgrade watch", "err", err)
+			return func() {}
+		}
+	}
+	debounce := a.watch.debounce
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(watchInterval)
+		defer t.Stop()
+		sum := ""
+		if binary {
+			sum = checksum(path)
+		}
+		prev, _ := os.Stat(path)
+		var changed time.Time
+		for {
+			select {
+			case now := <-t.C:
+				fi, _ := os.Stat(path)
+				if modified(prev, fi) {
+					prev, changed = fi, now
+					continue
+				}
+				if changed.IsZero() || now.Sub(changed) < debounce || fi == nil {
+					continue
+				}
+				changed = time.Time{}
+				if binary {
+					s := checksum(path)
+					if s == "" || s == sum {
+						continue
+					}
+					sum = s
+				}
+				a.log(slog.LevelInfo, "again: file changed, upgrading", "path", path)
+				if !a.deliver(SIGUSR2, "watch") {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// modified reports whether the file described by prev was replaced,
+// removed or written to since, as described by fi.
+func modified(prev, fi os.FileInfo) bool {
+	if prev == nil || fi == nil {
+		return (prev == nil) != (fi == nil)
+	}
+	return !os.SameFile(prev, fi) || !prev.ModTime().Equal(fi.ModTime()) || prev.Size() != fi.Size()
+}
@@ -0,0 +1,120 @@
+package again
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"syscall"
+)
+
+// WrapFunc wraps the listener of a service, e.g. with netutil.LimitListener.
+type WrapFunc func(net.Listener) net.Listener
+
+// WithListenerWrapper makes the named service serve through wrap. Listen
+// applies it to the listener it is given, which keeps providing the
+// descriptor, and ListenFrom applies it again to the inherited listener, so
+// limits and other wrappers survive upgrades. For example, to keep accepting
+// the PROXY protocol with github.com/pires/go-proxyproto:
+//
+//	again.WithListenerWrapper("edge", func(l net.Listener) net.Listener {
+//		return &proxyproto.Listener{Listener: l, Policy: policy}
+//	})
+//
+// The wrapper should be configured identically in every generation.
+func WithListenerWrapper(name string, wrap WrapFunc) Option {
+	return optionFunc(func(a *Again) {
+		if a.wrappers == nil {
+			a.wrappers = make(map[string]WrapFunc)
+		}
+		a.wrappers[name] = wrap
+	})
+}
+
+// ListenWrapped registers ls, a listener that hides its descriptor behind a
+// wrapper, together with raw, the socket it wraps. Register the wrapper with
+// WithListenerWrapper as well so the next generation wraps the inherited
+// listener the same way; otherwise it gets the bare listener.
+func (a *Again) ListenWrapped(name string, ls net.Listener, raw syscall.Conn, opts ...RegisterOption) error {
+	fd, err := connFd(raw)
+	if err != nil {
+		return err
+	}
+	s := &Service{
+		Name:       name,
+		FdName:     ListerName(ls),
+		Listener:   ls,
+		Descriptor: fd,
+	}
+	if l, ok := raw.(net.Listener); ok {
+		s.raw = l
+	}
+	return a.store(s, opts)
+}
+
+// unwrapListener returns the listener ls wraps, following Unwrap methods and
+// exported Listener fields as used by proxy protocol and TLS listeners, or
+// ls itself.
+func unwrapListener(ls net.Listener) net.Listener {
+	for {
+		switch ls.(type) {
+		case syscall.Conn, FdListener:
+			return ls
+		}
+		var inner net.Listener
+		if u, ok := ls.(interface{ Unwrap() net.Listener }); ok {
+			inner = u.Unwrap()
+		} else {
+			v := reflect.ValueOf(ls)
+			for hasElem(v) && !v.IsNil() {
+				v = v.Elem()
+			}
+			if v.Kind() != reflect.Struct {
+				return ls
+			}
+			f := v.FieldByName("Listener")
+			if !f.IsValid() || !f.CanInterface() {
+				return ls
+			}
+			inner, _ = f.Interface().(net.Listener)
+		}
+		if inner == nil {
+			return ls
+		}
+		ls = inner
+	}
+}
+
+// wrap applies the wrapper registered for s, if any.
+func (a *Again) wrap(s *Service) {
+	wrap := a.wrappers[s.Name]
+	if wrap == nil || s.PacketConn() != nil || s.File() != nil {
+		return
+	}
+	s.raw = s.Listener
+	s.Listener = wrap(s.Listener)
+}
+
+// rawListener returns the listener owning the descriptor of s, looking
+// through wrappers and connection tracking.
+func (s *Service) rawListener() net.Listener {
+	if s.raw != nil {
+		return s.raw
+	}
+	if t, ok := s.Listener.(*trackingListener); ok {
+		return t.Listener
+	}
+	return s.Listener
+}
+
+// connFd returns the descriptor of sc.
+func connFd(sc syscall.Conn) (uintptr, error) {
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var fd uintptr
+	if err := rc.Control(func(f uintptr) { fd = f }); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrUnsupportedListener, err)
+	}
+	return fd, nil
+}